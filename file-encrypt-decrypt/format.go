@@ -0,0 +1,295 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/scrypt"
+)
+
+// Encrypted files start with a header that records everything needed to
+// decrypt them, so changing the defaults below never strands old files:
+//
+//	magic   "FEDC"
+//	version 1 byte
+//	kdf     1 byte, params 3 x uint32 big endian
+//	salt    1 byte length + salt
+//	cipher  1 byte
+//	nonce   1 byte length + nonce
+//	chunk   uint32 chunk size     (version 2 only)
+//	size    uint64 plaintext size (version 2 only)
+//
+// Version 1 seals the whole file in one AEAD message. Version 2 is the
+// chunked format described in stream.go, where nonce is a prefix that the
+// chunk number is appended to. In both, the header is passed to the AEAD as
+// additional data, so tampering with it fails decryption just like
+// tampering with the ciphertext.
+var magic = []byte("FEDC")
+
+const (
+	formatV1      = 1
+	formatV2      = 2
+	formatVersion = formatV2
+)
+
+// Key derivation functions. For Argon2id the params are time, memory in KiB
+// and threads; for scrypt they are N, r and p.
+const (
+	KDFArgon2id byte = 1
+	KDFScrypt   byte = 2
+)
+
+// AEAD ciphers.
+const (
+	CipherAES256GCM         byte = 1
+	CipherXChaCha20Poly1305 byte = 2
+)
+
+const (
+	keySize  = 32
+	saltSize = 16
+)
+
+// Upper bounds on KDF parameters accepted from a header, so a crafted file
+// cannot make decryption allocate unbounded memory. Both KDFs are capped at
+// 1 GiB, sixteen times the default Argon2id profile.
+const (
+	maxArgon2Memory = 1 << 20 // KiB, 1 GiB
+	maxArgon2Time   = 100
+	maxScryptMemory = 1 << 30 // bytes, 128 * N * r
+	maxScryptP      = 64
+)
+
+var (
+	ErrNotEncrypted   = errors.New("not an encrypted file")
+	ErrUnsupported    = errors.New("unsupported file format")
+	ErrDecryptFailed  = errors.New("wrong password or corrupted file")
+	ErrInvalidOptions = errors.New("invalid encryption options")
+)
+
+// Options selects the algorithms and cost used for new files.
+type Options struct {
+	KDF       byte
+	Params    [3]uint32
+	Cipher    byte
+	ChunkSize uint32
+}
+
+const (
+	defaultChunkSize = 1 << 20
+	maxChunkSize     = 64 << 20
+
+	// maxChunks keeps the index, which is read into memory whole, at or
+	// below 256 MiB.
+	maxChunks = 8 << 20
+)
+
+// DefaultOptions follow the RFC 9106 second recommended Argon2id profile.
+var DefaultOptions = Options{
+	KDF:       KDFArgon2id,
+	Params:    [3]uint32{3, 64 * 1024, 4},
+	Cipher:    CipherAES256GCM,
+	ChunkSize: defaultChunkSize,
+}
+
+// ScryptOptions use the cost recommended for interactive use in the scrypt
+// paper's 2017 update.
+var ScryptOptions = Options{
+	KDF:       KDFScrypt,
+	Params:    [3]uint32{1 << 15, 8, 1},
+	Cipher:    CipherAES256GCM,
+	ChunkSize: defaultChunkSize,
+}
+
+// Header describes how a file was encrypted.
+type Header struct {
+	Version   byte
+	KDF       byte
+	Params    [3]uint32
+	Salt      []byte
+	Cipher    byte
+	Nonce     []byte
+	ChunkSize uint32
+	Size      uint64
+}
+
+func (h *Header) MarshalBinary() ([]byte, error) {
+	if len(h.Salt) > 255 || len(h.Nonce) > 255 {
+		return nil, fmt.Errorf("header field too long")
+	}
+	var buf bytes.Buffer
+	buf.Write(magic)
+	buf.WriteByte(h.Version)
+	buf.WriteByte(h.KDF)
+	for _, p := range h.Params {
+		binary.Write(&buf, binary.BigEndian, p)
+	}
+	buf.WriteByte(byte(len(h.Salt)))
+	buf.Write(h.Salt)
+	buf.WriteByte(h.Cipher)
+	buf.WriteByte(byte(len(h.Nonce)))
+	buf.Write(h.Nonce)
+	if h.Version >= formatV2 {
+		binary.Write(&buf, binary.BigEndian, h.ChunkSize)
+		binary.Write(&buf, binary.BigEndian, h.Size)
+	}
+	return buf.Bytes(), nil
+}
+
+// ReadHeader parses the header at the start of r and returns it along with
+// its raw bytes.
+func ReadHeader(r io.Reader) (*Header, []byte, error) {
+	var raw bytes.Buffer
+	r = io.TeeReader(r, &raw)
+
+	prefix := make([]byte, len(magic)+2)
+	if _, err := io.ReadFull(r, prefix); err != nil {
+		return nil, nil, ErrNotEncrypted
+	}
+	if !bytes.Equal(prefix[:len(magic)], magic) {
+		return nil, nil, ErrNotEncrypted
+	}
+	h := &Header{Version: prefix[len(magic)], KDF: prefix[len(magic)+1]}
+	if h.Version != formatV1 && h.Version != formatV2 {
+		return nil, nil, fmt.Errorf("%w: version %d", ErrUnsupported, h.Version)
+	}
+	if err := binary.Read(r, binary.BigEndian, &h.Params); err != nil {
+		return nil, nil, fmt.Errorf("failed to read header: %w", err)
+	}
+	var err error
+	if h.Salt, err = readField(r); err != nil {
+		return nil, nil, err
+	}
+	cipherID := make([]byte, 1)
+	if _, err := io.ReadFull(r, cipherID); err != nil {
+		return nil, nil, fmt.Errorf("failed to read header: %w", err)
+	}
+	h.Cipher = cipherID[0]
+	if h.Nonce, err = readField(r); err != nil {
+		return nil, nil, err
+	}
+	if h.Version >= formatV2 {
+		if err := binary.Read(r, binary.BigEndian, &h.ChunkSize); err != nil {
+			return nil, nil, fmt.Errorf("failed to read header: %w", err)
+		}
+		if err := binary.Read(r, binary.BigEndian, &h.Size); err != nil {
+			return nil, nil, fmt.Errorf("failed to read header: %w", err)
+		}
+		if h.ChunkSize == 0 || h.ChunkSize > maxChunkSize {
+			return nil, nil, fmt.Errorf("%w: chunk size %d", ErrUnsupported, h.ChunkSize)
+		}
+		// Nothing in the header is authenticated yet, so bound the sizes
+		// that decryption allocates from before trusting them.
+		if h.Size > math.MaxInt64 || chunkCount(int64(h.Size), h.ChunkSize) > maxChunks {
+			return nil, nil, fmt.Errorf("%w: size %d with chunk size %d", ErrUnsupported, h.Size, h.ChunkSize)
+		}
+	}
+	return h, raw.Bytes(), nil
+}
+
+func readField(r io.Reader) ([]byte, error) {
+	n := make([]byte, 1)
+	if _, err := io.ReadFull(r, n); err != nil {
+		return nil, fmt.Errorf("failed to read header: %w", err)
+	}
+	field := make([]byte, n[0])
+	if _, err := io.ReadFull(r, field); err != nil {
+		return nil, fmt.Errorf("failed to read header: %w", err)
+	}
+	return field, nil
+}
+
+// deriveKey runs the KDF named by kdf over password.
+func deriveKey(password []byte, kdf byte, params [3]uint32, salt []byte) ([]byte, error) {
+	switch kdf {
+	case KDFArgon2id:
+		t, m, p := params[0], params[1], params[2]
+		if t < 1 || t > maxArgon2Time || m < 8*p || m > maxArgon2Memory || p < 1 || p > 255 {
+			return nil, fmt.Errorf("%w: argon2id parameters out of range", ErrUnsupported)
+		}
+		return argon2.IDKey(password, salt, t, m, uint8(p), keySize), nil
+	case KDFScrypt:
+		n, r, p := params[0], params[1], params[2]
+		if n < 2 || n&(n-1) != 0 || r < 1 || p < 1 || p > maxScryptP || 128*uint64(n)*uint64(r) > maxScryptMemory {
+			return nil, fmt.Errorf("%w: scrypt parameters out of range", ErrUnsupported)
+		}
+		key, err := scrypt.Key(password, salt, int(n), int(r), int(p), keySize)
+		if err != nil {
+			return nil, fmt.Errorf("failed to derive key: %w", err)
+		}
+		return key, nil
+	}
+	return nil, fmt.Errorf("%w: kdf %d", ErrUnsupported, kdf)
+}
+
+func newAEAD(id byte, key []byte) (cipher.AEAD, error) {
+	switch id {
+	case CipherAES256GCM:
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create cipher block: %w", err)
+		}
+		return cipher.NewGCM(block)
+	case CipherXChaCha20Poly1305:
+		return chacha20poly1305.NewX(key)
+	}
+	return nil, fmt.Errorf("%w: cipher %d", ErrUnsupported, id)
+}
+
+// newAEAD derives the key for a header and returns the AEAD it keys.
+func (h *Header) newAEAD(password []byte) (cipher.AEAD, error) {
+	key, err := deriveKey(password, h.KDF, h.Params, h.Salt)
+	if err != nil {
+		return nil, err
+	}
+	return newAEAD(h.Cipher, key)
+}
+
+// Encrypt seals plaintext under a key derived from password in the current
+// format and returns the header followed by the ciphertext.
+func Encrypt(plaintext, password []byte, opts Options) ([]byte, error) {
+	var buf bytes.Buffer
+	err := EncryptStream(&buf, bytes.NewReader(plaintext), int64(len(plaintext)), password, opts)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Decrypt reverses Encrypt for any supported format version.
+func Decrypt(data, password []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := DecryptStream(&buf, bytes.NewReader(data), password); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// decryptV1 opens a version 1 file, whose body is a single AEAD message.
+func decryptV1(w io.Writer, h *Header, header []byte, body io.Reader, password []byte) error {
+	aead, err := h.newAEAD(password)
+	if err != nil {
+		return err
+	}
+	if len(h.Nonce) != aead.NonceSize() {
+		return fmt.Errorf("%w: bad nonce size", ErrUnsupported)
+	}
+	ciphertext, err := io.ReadAll(body)
+	if err != nil {
+		return fmt.Errorf("failed to read ciphertext: %w", err)
+	}
+	plaintext, err := aead.Open(nil, h.Nonce, ciphertext, header)
+	if err != nil {
+		return ErrDecryptFailed
+	}
+	_, err = w.Write(plaintext)
+	return err
+}
@@ -1,94 +1,440 @@
 package main
 
 import (
-	"bytes"
-	"crypto/aes"
-	"crypto/cipher"
-	"crypto/rand"
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
+	"flag"
 	"fmt"
 	"io"
-	"io/ioutil"
-
-	shell "github.com/ipfs/go-ipfs-api"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
 )
 
-func EncryptFile(filename string, password string) ([]byte, error) {
-	plaintext, err := ioutil.ReadFile(filename)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read file: %w", err)
+const usage = `Usage: file-encrypt-decrypt <command> [flags] [args]
+
+Commands:
+  encrypt <file>   Encrypt a file locally
+  decrypt <file>   Decrypt a local encrypted file
+  upload <file>    Encrypt a file and put it in the blob store
+  fetch <id>       Fetch a blob by ID and decrypt it
+  delete <id>      Delete a blob and its manifest entry
+  list             List uploaded files
+
+The password is read from $FILE_ENCRYPT_PASSWORD or prompted for.
+Run "file-encrypt-decrypt <command> -h" for the flags of a command.
+`
+
+func main() {
+	log.SetFlags(0)
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	commands := map[string]func(args []string) error{
+		"encrypt": runEncrypt,
+		"decrypt": runDecrypt,
+		"upload":  runUpload,
+		"fetch":   runFetch,
+		"delete":  runDelete,
+		"list":    runList,
+	}
+	run, ok := commands[os.Args[1]]
+	if !ok {
+		if os.Args[1] != "-h" && os.Args[1] != "help" {
+			fmt.Fprintf(os.Stderr, "Unknown command %q\n\n", os.Args[1])
+		}
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	if err := run(os.Args[2:]); err != nil {
+		log.Fatalf("%s: %v", os.Args[1], err)
+	}
+}
+
+// parseArgs parses fs and requires exactly one positional argument.
+func parseArgs(fs *flag.FlagSet, args []string, name string) (string, error) {
+	if err := fs.Parse(args); err != nil {
+		return "", err
+	}
+	if fs.NArg() != 1 {
+		return "", fmt.Errorf("expected one %s argument", name)
+	}
+	return fs.Arg(0), nil
+}
+
+// cipherFlags registers the algorithm choices for new files.
+func cipherFlags(fs *flag.FlagSet) func() (Options, error) {
+	kdf := fs.String("kdf", "argon2id", "Key derivation function: argon2id or scrypt")
+	aead := fs.String("cipher", "aes-256-gcm", "Cipher: aes-256-gcm or xchacha20-poly1305")
+	chunkSize := fs.Uint("chunk-size", defaultChunkSize, "Plaintext bytes per encrypted chunk")
+	return func() (Options, error) {
+		var opts Options
+		switch *kdf {
+		case "argon2id":
+			opts = DefaultOptions
+		case "scrypt":
+			opts = ScryptOptions
+		default:
+			return Options{}, fmt.Errorf("unknown kdf %q", *kdf)
+		}
+		switch *aead {
+		case "aes-256-gcm":
+			opts.Cipher = CipherAES256GCM
+		case "xchacha20-poly1305":
+			opts.Cipher = CipherXChaCha20Poly1305
+		default:
+			return Options{}, fmt.Errorf("unknown cipher %q", *aead)
+		}
+		if *chunkSize == 0 || *chunkSize > maxChunkSize {
+			return Options{}, fmt.Errorf("chunk size must be between 1 and %d", maxChunkSize)
+		}
+		opts.ChunkSize = uint32(*chunkSize)
+		return opts, nil
 	}
+}
 
-	block, err := aes.NewCipher([]byte(password))
+// output is a file being written through a temporary file, so a failed
+// decryption never leaves partial plaintext under the final name.
+type output struct {
+	*os.File
+	path string
+}
+
+// createOutput refuses to replace an existing file unless force is set.
+func createOutput(path string, force bool) (*output, error) {
+	if !force {
+		if _, err := os.Stat(path); err == nil {
+			return nil, fmt.Errorf("%s already exists; use -force to overwrite", path)
+		}
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-*")
 	if err != nil {
-		return nil, fmt.Errorf("failed to create cipher block: %w", err)
+		return nil, fmt.Errorf("failed to create output file: %w", err)
 	}
+	return &output{File: f, path: path}, nil
+}
 
-	ciphertext := make([]byte, aes.BlockSize+len(plaintext))
-	iv := ciphertext[:aes.BlockSize]
-	if _, err := io.ReadFull(rand.Reader, iv); err != nil {
-		return nil, fmt.Errorf("failed to generate IV: %w", err)
+// commit moves the finished file into place.
+func (o *output) commit() error {
+	if err := o.Close(); err != nil {
+		os.Remove(o.Name())
+		return fmt.Errorf("failed to write output file: %w", err)
+	}
+	if err := os.Rename(o.Name(), o.path); err != nil {
+		os.Remove(o.Name())
+		return fmt.Errorf("failed to write output file: %w", err)
 	}
+	return nil
+}
 
-	stream := cipher.NewCFBEncrypter(block, iv)
-	stream.XORKeyStream(ciphertext[aes.BlockSize:], plaintext)
+func (o *output) abort() {
+	o.Close()
+	os.Remove(o.Name())
+}
+
+// encryptFile streams path through EncryptStream into w and returns the
+// plaintext size and SHA-256.
+func encryptFile(w io.Writer, path string, password []byte, opts Options) (int64, string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, "", fmt.Errorf("failed to read file: %w", err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return 0, "", fmt.Errorf("failed to read file: %w", err)
+	}
 
-	return ciphertext, nil
+	h := sha256.New()
+	if err := EncryptStream(w, io.TeeReader(f, h), info.Size(), password, opts); err != nil {
+		return 0, "", err
+	}
+	return info.Size(), hex.EncodeToString(h.Sum(nil)), nil
 }
 
-func AddFileToIPFS(data []byte) (string, error) {
-	sh := shell.NewShell("localhost:5001")
-	hash, err := sh.Add(bytes.NewReader(data))
+func runEncrypt(args []string) error {
+	fs := flag.NewFlagSet("encrypt", flag.ExitOnError)
+	options := cipherFlags(fs)
+	out := fs.String("o", "", "Output file (default <file>.enc)")
+	force := fs.Bool("force", false, "Overwrite the output file")
+	path, err := parseArgs(fs, args, "file")
+	if err != nil {
+		return err
+	}
+	opts, err := options()
+	if err != nil {
+		return err
+	}
+	if *out == "" {
+		*out = path + ".enc"
+	}
+
+	password, err := readPassword(true)
+	if err != nil {
+		return err
+	}
+	o, err := createOutput(*out, *force)
 	if err != nil {
-		return "", fmt.Errorf("failed to upload to IPFS: %w", err)
+		return err
 	}
-	return hash, nil
+	if _, _, err := encryptFile(o, path, password, opts); err != nil {
+		o.abort()
+		return err
+	}
+	if err := o.commit(); err != nil {
+		return err
+	}
+	fmt.Printf("Encrypted %s to %s\n", path, *out)
+	return nil
 }
 
-func DecryptFile(data []byte, password string) ([]byte, error) {
-	block, err := aes.NewCipher([]byte(password))
+func runDecrypt(args []string) error {
+	fs := flag.NewFlagSet("decrypt", flag.ExitOnError)
+	out := fs.String("o", "", "Output file (default <file> without .enc)")
+	force := fs.Bool("force", false, "Overwrite the output file")
+	path, err := parseArgs(fs, args, "file")
 	if err != nil {
-		return nil, fmt.Errorf("failed to create cipher block: %w", err)
+		return err
+	}
+	if *out == "" {
+		*out = strings.TrimSuffix(path, ".enc")
+		if *out == path {
+			*out = path + ".dec"
+		}
 	}
 
-	if len(data) < aes.BlockSize {
-		return nil, errors.New("ciphertext too short")
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to read file: %w", err)
+	}
+	defer f.Close()
+	password, err := readPassword(false)
+	if err != nil {
+		return err
+	}
+	o, err := createOutput(*out, *force)
+	if err != nil {
+		return err
+	}
+	if err := DecryptStream(o, bufio.NewReader(f), password); err != nil {
+		o.abort()
+		return err
 	}
+	if err := o.commit(); err != nil {
+		return err
+	}
+	fmt.Printf("Decrypted %s to %s\n", path, *out)
+	return nil
+}
 
-	iv := data[:aes.BlockSize]
-	data = data[aes.BlockSize:]
+// storeCommand sets up the flags shared by commands that use the blob store
+// and the manifest.
+type storeCommand struct {
+	fs           *flag.FlagSet
+	store        StoreConfig
+	manifestPath string
+}
 
-	stream := cipher.NewCFBDecrypter(block, iv)
-	stream.XORKeyStream(data, data)
+func newStoreCommand(name string) *storeCommand {
+	c := &storeCommand{fs: flag.NewFlagSet(name, flag.ExitOnError)}
+	c.store.RegisterFlags(c.fs)
+	c.fs.StringVar(&c.manifestPath, "manifest", "manifest.json", "Path to the local manifest")
+	return c
+}
 
-	return data, nil
+func (c *storeCommand) open() (BlobStore, *Manifest, error) {
+	store, err := OpenStore(c.store)
+	if err != nil {
+		return nil, nil, err
+	}
+	manifest, err := LoadManifest(c.manifestPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	return store, manifest, nil
 }
 
-func main() {
-	encryptedData, err := EncryptFile("example.txt", "mysecretpassword")
+func runUpload(args []string) error {
+	c := newStoreCommand("upload")
+	options := cipherFlags(c.fs)
+	path, err := parseArgs(c.fs, args, "file")
+	if err != nil {
+		return err
+	}
+	opts, err := options()
+	if err != nil {
+		return err
+	}
+	store, manifest, err := c.open()
+	if err != nil {
+		return err
+	}
+	password, err := readPassword(true)
 	if err != nil {
-		panic(err)
+		return err
 	}
 
-	hash, err := AddFileToIPFS(encryptedData)
+	// Encrypt straight into the store's upload so the file is never held
+	// in memory.
+	pr, pw := io.Pipe()
+	type result struct {
+		size int64
+		sum  string
+	}
+	done := make(chan result, 1)
+	go func() {
+		size, sum, err := encryptFile(pw, path, password, opts)
+		pw.CloseWithError(err)
+		done <- result{size, sum}
+	}()
+	id, err := store.Put(context.Background(), pr)
+	pr.CloseWithError(err)
+	res := <-done
+	if err != nil {
+		return err
+	}
+	encryptedSize, err := EncryptedSize(res.size, opts)
 	if err != nil {
-		panic(err)
+		return err
 	}
 
-	sh := shell.NewShell("localhost:5001")
-	data, err := sh.Cat(hash)
+	manifest.Add(ManifestEntry{
+		ID:            id,
+		Name:          filepath.Base(path),
+		Size:          res.size,
+		SHA256:        res.sum,
+		EncryptedSize: encryptedSize,
+		Store:         c.store.Backend,
+		Stored:        time.Now().UTC(),
+	})
+	if err := manifest.Save(); err != nil {
+		return err
+	}
+	fmt.Println(id)
+	return nil
+}
+
+func runFetch(args []string) error {
+	c := newStoreCommand("fetch")
+	out := c.fs.String("o", "", "Output file (default the original file name)")
+	force := c.fs.Bool("force", false, "Overwrite the output file")
+	offset := c.fs.Int64("offset", 0, "Start of the plaintext range to fetch")
+	length := c.fs.Int64("length", -1, "Length of the plaintext range to fetch (default to the end)")
+	id, err := parseArgs(c.fs, args, "ID")
 	if err != nil {
-		panic(err)
+		return err
+	}
+	store, manifest, err := c.open()
+	if err != nil {
+		return err
+	}
+	entry, known := manifest.Find(id)
+	if *out == "" {
+		if !known {
+			return fmt.Errorf("%s is not in the manifest; use -o to name the output file", id)
+		}
+		*out = entry.Name
 	}
+	partial := *offset != 0 || *length >= 0
+	if partial && *length < 0 {
+		if !known {
+			return fmt.Errorf("%s is not in the manifest; use -length to give the range", id)
+		}
+		*length = entry.Size - *offset
+	}
+
+	password, err := readPassword(false)
+	if err != nil {
+		return err
+	}
+	o, err := createOutput(*out, *force)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
 
-	decryptedData, err := DecryptFile(data, "mysecretpassword")
+	if partial {
+		ranged, ok := store.(RangeGetter)
+		if !ok {
+			o.abort()
+			return fmt.Errorf("the %s store does not support ranged reads", c.store.Backend)
+		}
+		err = DecryptRange(o, blobReaderAt{ctx, ranged, id}, password, *offset, *length)
+		if err != nil {
+			o.abort()
+			return err
+		}
+		if err := o.commit(); err != nil {
+			return err
+		}
+		fmt.Printf("Fetched bytes %d-%d of %s to %s\n", *offset, *offset+*length, id, *out)
+		return nil
+	}
+
+	rc, err := store.Get(ctx, id)
 	if err != nil {
-		panic(err)
+		o.abort()
+		return err
+	}
+	defer rc.Close()
+	h := sha256.New()
+	if err := DecryptStream(io.MultiWriter(o, h), bufio.NewReader(rc), password); err != nil {
+		o.abort()
+		return err
 	}
+	if known && hex.EncodeToString(h.Sum(nil)) != entry.SHA256 {
+		o.abort()
+		return fmt.Errorf("%s does not match the hash recorded in the manifest", id)
+	}
+	if err := o.commit(); err != nil {
+		return err
+	}
+	fmt.Printf("Fetched %s to %s\n", id, *out)
+	return nil
+}
 
-	err = ioutil.WriteFile("decrypted_example.txt", decryptedData, 0644)
+func runDelete(args []string) error {
+	c := newStoreCommand("delete")
+	id, err := parseArgs(c.fs, args, "ID")
 	if err != nil {
-		panic(err)
+		return err
+	}
+	store, manifest, err := c.open()
+	if err != nil {
+		return err
+	}
+	if err := store.Delete(context.Background(), id); err != nil && !errors.Is(err, ErrBlobNotFound) {
+		return err
+	}
+	if manifest.Remove(id) {
+		return manifest.Save()
+	}
+	return nil
+}
+
+func runList(args []string) error {
+	fs := flag.NewFlagSet("list", flag.ExitOnError)
+	manifestPath := fs.String("manifest", "manifest.json", "Path to the local manifest")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	manifest, err := LoadManifest(*manifestPath)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tNAME\tSIZE\tSTORE\tSTORED")
+	for _, e := range manifest.Entries {
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\n", e.ID, e.Name, e.Size, e.Store, e.Stored.Local().Format(time.DateTime))
 	}
-	fmt.Println("File decrypted and saved as decrypted_example.txt")
+	return w.Flush()
 }
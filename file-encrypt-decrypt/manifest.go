@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ManifestEntry records one stored file.
+type ManifestEntry struct {
+	ID            string    `json:"id"`
+	Name          string    `json:"name"`
+	Size          int64     `json:"size"`
+	SHA256        string    `json:"sha256"`
+	EncryptedSize int64     `json:"encrypted_size"`
+	Store         string    `json:"store"`
+	Stored        time.Time `json:"stored"`
+}
+
+// Manifest is the local index of what has been uploaded, kept as JSON so it
+// can be read and backed up by hand.
+type Manifest struct {
+	path    string
+	Entries []ManifestEntry `json:"entries"`
+}
+
+func LoadManifest(path string) (*Manifest, error) {
+	m := &Manifest{path: path}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return m, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %w", err)
+	}
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest %s: %w", path, err)
+	}
+	return m, nil
+}
+
+// Save writes the manifest atomically.
+func (m *Manifest) Save() error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(m.path), ".manifest-*")
+	if err != nil {
+		return fmt.Errorf("failed to save manifest: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to save manifest: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to save manifest: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), m.path); err != nil {
+		return fmt.Errorf("failed to save manifest: %w", err)
+	}
+	return nil
+}
+
+// Add records e, replacing any existing entry with the same ID.
+func (m *Manifest) Add(e ManifestEntry) {
+	for i := range m.Entries {
+		if m.Entries[i].ID == e.ID {
+			m.Entries[i] = e
+			return
+		}
+	}
+	m.Entries = append(m.Entries, e)
+}
+
+func (m *Manifest) Find(id string) (ManifestEntry, bool) {
+	for _, e := range m.Entries {
+		if e.ID == id {
+			return e, true
+		}
+	}
+	return ManifestEntry{}, false
+}
+
+func (m *Manifest) Remove(id string) bool {
+	for i, e := range m.Entries {
+		if e.ID == id {
+			m.Entries = append(m.Entries[:i], m.Entries[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
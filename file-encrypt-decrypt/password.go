@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+
+	"golang.org/x/term"
+)
+
+// passwordEnv names the environment variable checked before prompting.
+const passwordEnv = "FILE_ENCRYPT_PASSWORD"
+
+// readPassword takes the password from the environment or, failing that,
+// prompts on the terminal. With confirm set the prompt asks twice.
+func readPassword(confirm bool) ([]byte, error) {
+	if p := os.Getenv(passwordEnv); p != "" {
+		return []byte(p), nil
+	}
+
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return nil, fmt.Errorf("no terminal to prompt for a password; set %s", passwordEnv)
+	}
+	defer tty.Close()
+
+	prompt := func(label string) ([]byte, error) {
+		fmt.Fprint(tty, label)
+		p, err := term.ReadPassword(int(tty.Fd()))
+		fmt.Fprintln(tty)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read password: %w", err)
+		}
+		return p, nil
+	}
+
+	password, err := prompt("Password: ")
+	if err != nil {
+		return nil, err
+	}
+	if len(password) == 0 {
+		return nil, errors.New("password must not be empty")
+	}
+	if confirm {
+		again, err := prompt("Confirm password: ")
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(password, again) {
+			return nil, errors.New("passwords do not match")
+		}
+	}
+	return password, nil
+}
@@ -0,0 +1,354 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ipfs/boxo/files"
+	shell "github.com/ipfs/go-ipfs-api"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+var ErrBlobNotFound = errors.New("blob not found")
+
+// BlobStore keeps encrypted blobs somewhere and hands back an ID that can
+// be used to fetch or delete them later.
+type BlobStore interface {
+	Put(ctx context.Context, r io.Reader) (string, error)
+	Get(ctx context.Context, id string) (io.ReadCloser, error)
+	Delete(ctx context.Context, id string) error
+}
+
+// RangeGetter is implemented by stores that can return part of a blob, so
+// DecryptRange can fetch only the chunks it needs.
+type RangeGetter interface {
+	GetRange(ctx context.Context, id string, offset, length int64) (io.ReadCloser, error)
+}
+
+// blobReaderAt adapts a RangeGetter to io.ReaderAt.
+type blobReaderAt struct {
+	ctx   context.Context
+	store RangeGetter
+	id    string
+}
+
+func (b blobReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	rc, err := b.store.GetRange(b.ctx, b.id, off, int64(len(p)))
+	if err != nil {
+		return 0, err
+	}
+	defer rc.Close()
+	n, err := io.ReadFull(rc, p)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return n, err
+}
+
+// Store backends.
+const (
+	storeIPFS  = "ipfs"
+	storeLocal = "local"
+	storeS3    = "s3"
+)
+
+type StoreConfig struct {
+	Backend string
+
+	IPFSAPI string
+
+	Dir string
+
+	S3Endpoint string
+	S3Bucket   string
+	S3Region   string
+	S3Prefix   string
+	S3Insecure bool
+}
+
+// RegisterFlags adds the store selection flags to fs. S3 credentials are
+// read from S3_ACCESS_KEY and S3_SECRET_KEY rather than flags.
+func (c *StoreConfig) RegisterFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.Backend, "store", storeIPFS, "Blob store: ipfs, local or s3")
+	fs.StringVar(&c.IPFSAPI, "ipfs-api", "localhost:5001", "IPFS API address")
+	fs.StringVar(&c.Dir, "store-dir", "blobs", "Directory for the local store")
+	fs.StringVar(&c.S3Endpoint, "s3-endpoint", "s3.amazonaws.com", "S3-compatible endpoint host[:port]")
+	fs.StringVar(&c.S3Bucket, "s3-bucket", "", "S3 bucket")
+	fs.StringVar(&c.S3Region, "s3-region", "", "S3 region")
+	fs.StringVar(&c.S3Prefix, "s3-prefix", "", "Key prefix for objects in the bucket")
+	fs.BoolVar(&c.S3Insecure, "s3-insecure", false, "Use plain HTTP for the S3 endpoint")
+}
+
+// OpenStore returns the backend selected by c.
+func OpenStore(c StoreConfig) (BlobStore, error) {
+	switch c.Backend {
+	case storeIPFS:
+		return NewIPFSStore(c.IPFSAPI), nil
+	case storeLocal:
+		return NewLocalStore(c.Dir)
+	case storeS3:
+		return NewS3Store(c, os.Getenv("S3_ACCESS_KEY"), os.Getenv("S3_SECRET_KEY"))
+	}
+	return nil, fmt.Errorf("unknown store %q", c.Backend)
+}
+
+// IPFSStore adds blobs to an IPFS node through its HTTP API. IDs are CIDs;
+// Delete unpins, leaving removal to the node's garbage collector.
+type IPFSStore struct {
+	sh *shell.Shell
+}
+
+func NewIPFSStore(api string) *IPFSStore {
+	return &IPFSStore{sh: shell.NewShell(api)}
+}
+
+func (s *IPFSStore) Put(ctx context.Context, r io.Reader) (string, error) {
+	// Shell.Add has no context, so build the same request by hand.
+	dir := files.NewSliceDirectory([]files.DirEntry{files.FileEntry("", files.NewReaderFile(r))})
+	var out struct{ Hash string }
+	err := s.sh.Request("add").Body(files.NewMultiFileReader(dir, true, false)).Exec(ctx, &out)
+	if err != nil {
+		return "", fmt.Errorf("failed to upload to IPFS: %w", err)
+	}
+	return out.Hash, nil
+}
+
+func (s *IPFSStore) Get(ctx context.Context, id string) (io.ReadCloser, error) {
+	return s.cat(ctx, s.sh.Request("cat", id), id)
+}
+
+func (s *IPFSStore) GetRange(ctx context.Context, id string, offset, length int64) (io.ReadCloser, error) {
+	return s.cat(ctx, s.sh.Request("cat", id).Option("offset", offset).Option("length", length), id)
+}
+
+func (s *IPFSStore) cat(ctx context.Context, req *shell.RequestBuilder, id string) (io.ReadCloser, error) {
+	resp, err := req.Send(ctx)
+	if err != nil {
+		return nil, ipfsError("fetch", id, err)
+	}
+	if resp.Error != nil {
+		resp.Close()
+		return nil, ipfsError("fetch", id, resp.Error)
+	}
+	return resp.Output, nil
+}
+
+func (s *IPFSStore) Delete(ctx context.Context, id string) error {
+	if err := s.sh.Request("pin/rm", id).Option("recursive", true).Exec(ctx, nil); err != nil {
+		return ipfsError("unpin", id, err)
+	}
+	return nil
+}
+
+// ipfsError maps the node's errors for unknown or malformed CIDs and for
+// blobs that are not pinned to ErrBlobNotFound. The API reports these only
+// as messages, so they are matched by text.
+func ipfsError(op, id string, err error) error {
+	var apiErr *shell.Error
+	if errors.As(err, &apiErr) {
+		msg := strings.ToLower(apiErr.Message)
+		for _, text := range []string{"not found", "not pinned", "invalid path", "invalid cid"} {
+			if strings.Contains(msg, text) {
+				return fmt.Errorf("%w: %s", ErrBlobNotFound, id)
+			}
+		}
+	}
+	return fmt.Errorf("failed to %s %s from IPFS: %w", op, id, err)
+}
+
+// spool copies r into a temporary file in dir while hashing it, so content
+// addressed stores know the ID and size before they commit the blob. The
+// caller must remove the returned file.
+func spool(dir string, r io.Reader) (*os.File, string, int64, error) {
+	f, err := os.CreateTemp(dir, ".upload-*")
+	if err != nil {
+		return nil, "", 0, fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(f, h), r)
+	if err == nil {
+		_, err = f.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, "", 0, fmt.Errorf("failed to buffer blob: %w", err)
+	}
+	return f, hex.EncodeToString(h.Sum(nil)), n, nil
+}
+
+// validID reports whether id looks like a hex SHA-256 digest, which keeps
+// IDs from escaping the store's directory or prefix.
+func validID(id string) bool {
+	if len(id) != sha256.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(id)
+	return err == nil
+}
+
+// LocalStore keeps blobs in a directory, named by the SHA-256 of their
+// contents and sharded by the first two hex digits.
+type LocalStore struct {
+	dir string
+}
+
+func NewLocalStore(dir string) (*LocalStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create store directory: %w", err)
+	}
+	return &LocalStore{dir: dir}, nil
+}
+
+func (s *LocalStore) path(id string) string {
+	return filepath.Join(s.dir, id[:2], id)
+}
+
+func (s *LocalStore) Put(ctx context.Context, r io.Reader) (string, error) {
+	f, id, _, err := spool(s.dir, r)
+	if err != nil {
+		return "", err
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+
+	if err := os.MkdirAll(filepath.Dir(s.path(id)), 0700); err != nil {
+		return "", fmt.Errorf("failed to create store directory: %w", err)
+	}
+	if err := os.Rename(f.Name(), s.path(id)); err != nil {
+		return "", fmt.Errorf("failed to store blob: %w", err)
+	}
+	return id, nil
+}
+
+func (s *LocalStore) Get(ctx context.Context, id string) (io.ReadCloser, error) {
+	if !validID(id) {
+		return nil, fmt.Errorf("%w: %s", ErrBlobNotFound, id)
+	}
+	f, err := os.Open(s.path(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrBlobNotFound, id)
+	}
+	return f, err
+}
+
+type sectionReadCloser struct {
+	*io.SectionReader
+	io.Closer
+}
+
+func (s *LocalStore) GetRange(ctx context.Context, id string, offset, length int64) (io.ReadCloser, error) {
+	rc, err := s.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	f := rc.(*os.File)
+	return sectionReadCloser{io.NewSectionReader(f, offset, length), f}, nil
+}
+
+func (s *LocalStore) Delete(ctx context.Context, id string) error {
+	if !validID(id) {
+		return fmt.Errorf("%w: %s", ErrBlobNotFound, id)
+	}
+	err := os.Remove(s.path(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("%w: %s", ErrBlobNotFound, id)
+	}
+	return err
+}
+
+// S3Store keeps blobs in a bucket on any S3-compatible service, keyed by
+// the SHA-256 of their contents under an optional prefix.
+type S3Store struct {
+	client *minio.Client
+	bucket string
+	prefix string
+}
+
+func NewS3Store(c StoreConfig, accessKey, secretKey string) (*S3Store, error) {
+	if c.S3Bucket == "" {
+		return nil, fmt.Errorf("s3 bucket is required")
+	}
+	client, err := minio.New(c.S3Endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(accessKey, secretKey, ""),
+		Secure: !c.S3Insecure,
+		Region: c.S3Region,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create S3 client: %w", err)
+	}
+	return &S3Store{client: client, bucket: c.S3Bucket, prefix: c.S3Prefix}, nil
+}
+
+func (s *S3Store) Put(ctx context.Context, r io.Reader) (string, error) {
+	f, id, size, err := spool("", r)
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	_, err = s.client.PutObject(ctx, s.bucket, s.prefix+id, f, size,
+		minio.PutObjectOptions{ContentType: "application/octet-stream"})
+	if err != nil {
+		return "", fmt.Errorf("failed to upload to S3: %w", err)
+	}
+	return id, nil
+}
+
+func (s *S3Store) Get(ctx context.Context, id string) (io.ReadCloser, error) {
+	if !validID(id) {
+		return nil, fmt.Errorf("%w: %s", ErrBlobNotFound, id)
+	}
+	obj, err := s.client.GetObject(ctx, s.bucket, s.prefix+id, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch %s from S3: %w", id, err)
+	}
+	// GetObject is lazy; Stat surfaces a missing key before the caller
+	// starts reading.
+	if _, err := obj.Stat(); err != nil {
+		obj.Close()
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return nil, fmt.Errorf("%w: %s", ErrBlobNotFound, id)
+		}
+		return nil, fmt.Errorf("failed to fetch %s from S3: %w", id, err)
+	}
+	return obj, nil
+}
+
+func (s *S3Store) GetRange(ctx context.Context, id string, offset, length int64) (io.ReadCloser, error) {
+	if !validID(id) {
+		return nil, fmt.Errorf("%w: %s", ErrBlobNotFound, id)
+	}
+	if length <= 0 {
+		return io.NopCloser(strings.NewReader("")), nil
+	}
+	var opts minio.GetObjectOptions
+	if err := opts.SetRange(offset, offset+length-1); err != nil {
+		return nil, err
+	}
+	obj, err := s.client.GetObject(ctx, s.bucket, s.prefix+id, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch %s from S3: %w", id, err)
+	}
+	return obj, nil
+}
+
+func (s *S3Store) Delete(ctx context.Context, id string) error {
+	if !validID(id) {
+		return fmt.Errorf("%w: %s", ErrBlobNotFound, id)
+	}
+	if err := s.client.RemoveObject(ctx, s.bucket, s.prefix+id, minio.RemoveObjectOptions{}); err != nil {
+		return fmt.Errorf("failed to delete %s from S3: %w", id, err)
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestLocalStore(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewLocalStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	blob := []byte("0123456789abcdef")
+
+	id, err := store.Put(ctx, bytes.NewReader(blob))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !validID(id) {
+		t.Fatalf("Put returned ID %q, want a hex SHA-256", id)
+	}
+
+	rc, err := store.Get(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(rc)
+	rc.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, blob) {
+		t.Errorf("Get = %q, want %q", got, blob)
+	}
+
+	rc, err = store.GetRange(ctx, id, 4, 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err = io.ReadAll(rc)
+	rc.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "456789" {
+		t.Errorf("GetRange(4, 6) = %q, want %q", got, "456789")
+	}
+
+	if err := store.Delete(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Get(ctx, id); !errors.Is(err, ErrBlobNotFound) {
+		t.Errorf("Get after Delete error = %v, want ErrBlobNotFound", err)
+	}
+	if err := store.Delete(ctx, id); !errors.Is(err, ErrBlobNotFound) {
+		t.Errorf("second Delete error = %v, want ErrBlobNotFound", err)
+	}
+}
+
+// IDs come from the command line, so anything that is not a digest must be
+// refused before it is joined onto the store directory.
+func TestLocalStoreRejectsBadIDs(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewLocalStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{
+		strings.Repeat("ab", 32),
+		"../x",
+		"../" + strings.Repeat("ab", 30) + "x",
+		"",
+	} {
+		if _, err := store.Get(ctx, id); !errors.Is(err, ErrBlobNotFound) {
+			t.Errorf("Get(%q) error = %v, want ErrBlobNotFound", id, err)
+		}
+		if _, err := store.GetRange(ctx, id, 0, 1); !errors.Is(err, ErrBlobNotFound) {
+			t.Errorf("GetRange(%q) error = %v, want ErrBlobNotFound", id, err)
+		}
+		if err := store.Delete(ctx, id); !errors.Is(err, ErrBlobNotFound) {
+			t.Errorf("Delete(%q) error = %v, want ErrBlobNotFound", id, err)
+		}
+	}
+}
+
+// The IPFS API reports unknown and unpinned CIDs only as error messages;
+// they must still surface as ErrBlobNotFound so delete can tolerate them.
+func TestIPFSStoreNotFound(t *testing.T) {
+	node := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		switch r.URL.Path {
+		case "/api/v0/cat":
+			io.WriteString(w, `{"Message":"block was not found locally (offline): ipld: could not find QmMissing","Code":0,"Type":"error"}`)
+		case "/api/v0/pin/rm":
+			io.WriteString(w, `{"Message":"not pinned or pinned indirectly","Code":0,"Type":"error"}`)
+		default:
+			io.WriteString(w, `{"Message":"node is on fire","Code":0,"Type":"error"}`)
+		}
+	}))
+	defer node.Close()
+
+	ctx := context.Background()
+	store := NewIPFSStore(strings.TrimPrefix(node.URL, "http://"))
+	if _, err := store.Get(ctx, "QmMissing"); !errors.Is(err, ErrBlobNotFound) {
+		t.Errorf("Get error = %v, want ErrBlobNotFound", err)
+	}
+	if _, err := store.GetRange(ctx, "QmMissing", 0, 1); !errors.Is(err, ErrBlobNotFound) {
+		t.Errorf("GetRange error = %v, want ErrBlobNotFound", err)
+	}
+	if err := store.Delete(ctx, "QmMissing"); !errors.Is(err, ErrBlobNotFound) {
+		t.Errorf("Delete error = %v, want ErrBlobNotFound", err)
+	}
+	if _, err := store.Put(ctx, strings.NewReader("data")); err == nil || errors.Is(err, ErrBlobNotFound) {
+		t.Errorf("Put error = %v, want a plain failure", err)
+	}
+}
+
+func TestIPFSStoreHonorsContext(t *testing.T) {
+	node := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer node.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	store := NewIPFSStore(strings.TrimPrefix(node.URL, "http://"))
+	if _, err := store.Get(ctx, "QmMissing"); !errors.Is(err, context.Canceled) {
+		t.Errorf("Get error = %v, want context.Canceled", err)
+	}
+	if _, err := store.Put(ctx, strings.NewReader("data")); !errors.Is(err, context.Canceled) {
+		t.Errorf("Put error = %v, want context.Canceled", err)
+	}
+	if err := store.Delete(ctx, "QmMissing"); !errors.Is(err, context.Canceled) {
+		t.Errorf("Delete error = %v, want context.Canceled", err)
+	}
+}
@@ -0,0 +1,349 @@
+package main
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Version 2 files split the plaintext into fixed-size chunks that are
+// sealed independently, followed by a sealed index:
+//
+//	header
+//	chunk 0 .. n-1  AEAD(nonce prefix || i, plaintext chunk i, header)
+//	index           AEAD(nonce prefix || n, SHA-256 of each sealed chunk, header)
+//
+// The header fixes the plaintext size and chunk size, so every offset can be
+// computed up front and chunks can be neither dropped, reordered nor moved
+// to another file without failing authentication. The index lets a reader
+// check a sealed chunk against its hash before trying to open it, and check
+// a whole blob without holding it in memory.
+
+// maxHeaderSize bounds the header: every variable field is at most 255
+// bytes.
+const maxHeaderSize = 4 + 2 + 12 + 1 + 255 + 1 + 1 + 255 + 4 + 8
+
+// layout holds the offsets of a version 2 file.
+type layout struct {
+	headerSize int64
+	chunkSize  int64
+	size       int64
+	overhead   int64
+	chunks     int64
+}
+
+func newLayout(h *Header, headerSize int, overhead int) layout {
+	l := layout{
+		headerSize: int64(headerSize),
+		chunkSize:  int64(h.ChunkSize),
+		size:       int64(h.Size),
+		overhead:   int64(overhead),
+	}
+	l.chunks = chunkCount(l.size, h.ChunkSize)
+	return l
+}
+
+// chunkCount is the number of chunks size bytes are split into.
+func chunkCount(size int64, chunkSize uint32) int64 {
+	return (size + int64(chunkSize) - 1) / int64(chunkSize)
+}
+
+// plainLen is the plaintext length of chunk i.
+func (l layout) plainLen(i int64) int64 {
+	if i == l.chunks-1 {
+		return l.size - i*l.chunkSize
+	}
+	return l.chunkSize
+}
+
+func (l layout) chunkOffset(i int64) int64 {
+	return l.headerSize + i*(l.chunkSize+l.overhead)
+}
+
+func (l layout) indexOffset() int64 {
+	return l.headerSize + l.size + l.chunks*l.overhead
+}
+
+func (l layout) indexLen() int64 {
+	return l.chunks*sha256.Size + l.overhead
+}
+
+// total is the size of the whole encrypted file.
+func (l layout) total() int64 {
+	return l.indexOffset() + l.indexLen()
+}
+
+func chunkNonce(prefix []byte, i int64) []byte {
+	nonce := make([]byte, len(prefix)+8)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint64(nonce[len(prefix):], uint64(i))
+	return nonce
+}
+
+// EncryptStream encrypts exactly size bytes from r to w in the chunked
+// format, holding only one chunk and the index in memory.
+func EncryptStream(w io.Writer, r io.Reader, size int64, password []byte, opts Options) error {
+	if size < 0 {
+		return fmt.Errorf("%w: negative size", ErrInvalidOptions)
+	}
+	if opts.ChunkSize == 0 {
+		opts.ChunkSize = defaultChunkSize
+	}
+	if opts.ChunkSize > maxChunkSize {
+		return fmt.Errorf("%w: chunk size above %d", ErrInvalidOptions, maxChunkSize)
+	}
+	if chunkCount(size, opts.ChunkSize) > maxChunks {
+		return fmt.Errorf("%w: more than %d chunks, use a larger chunk size", ErrInvalidOptions, maxChunks)
+	}
+
+	h := &Header{
+		Version:   formatV2,
+		KDF:       opts.KDF,
+		Params:    opts.Params,
+		Salt:      make([]byte, saltSize),
+		Cipher:    opts.Cipher,
+		ChunkSize: opts.ChunkSize,
+		Size:      uint64(size),
+	}
+	if _, err := io.ReadFull(rand.Reader, h.Salt); err != nil {
+		return fmt.Errorf("failed to generate salt: %w", err)
+	}
+	aead, err := h.newAEAD(password)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidOptions, err)
+	}
+	h.Nonce = make([]byte, aead.NonceSize()-8)
+	if _, err := io.ReadFull(rand.Reader, h.Nonce); err != nil {
+		return fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	header, err := h.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(header); err != nil {
+		return fmt.Errorf("failed to write header: %w", err)
+	}
+
+	l := newLayout(h, len(header), aead.Overhead())
+	index := make([]byte, 0, l.chunks*sha256.Size)
+	plain := make([]byte, l.chunkSize)
+	sealed := make([]byte, 0, l.chunkSize+l.overhead)
+	for i := int64(0); i < l.chunks; i++ {
+		chunk := plain[:l.plainLen(i)]
+		if _, err := io.ReadFull(r, chunk); err != nil {
+			return fmt.Errorf("failed to read input: %w", err)
+		}
+		sealed = aead.Seal(sealed[:0], chunkNonce(h.Nonce, i), chunk, header)
+		sum := sha256.Sum256(sealed)
+		index = append(index, sum[:]...)
+		if _, err := w.Write(sealed); err != nil {
+			return fmt.Errorf("failed to write chunk %d: %w", i, err)
+		}
+	}
+	if n, _ := io.ReadFull(r, plain[:1]); n != 0 {
+		return fmt.Errorf("input is longer than %d bytes", size)
+	}
+
+	if _, err := w.Write(aead.Seal(nil, chunkNonce(h.Nonce, l.chunks), index, header)); err != nil {
+		return fmt.Errorf("failed to write index: %w", err)
+	}
+	return nil
+}
+
+// EncryptedSize returns the size of the encrypted form of size bytes under
+// opts, for the current format.
+func EncryptedSize(size int64, opts Options) (int64, error) {
+	aead, err := newAEAD(opts.Cipher, make([]byte, keySize))
+	if err != nil {
+		return 0, err
+	}
+	if opts.ChunkSize == 0 {
+		opts.ChunkSize = defaultChunkSize
+	}
+	h := &Header{
+		Version:   formatV2,
+		Params:    opts.Params,
+		Salt:      make([]byte, saltSize),
+		Nonce:     make([]byte, aead.NonceSize()-8),
+		ChunkSize: opts.ChunkSize,
+		Size:      uint64(size),
+	}
+	header, err := h.MarshalBinary()
+	if err != nil {
+		return 0, err
+	}
+	return newLayout(h, len(header), aead.Overhead()).total(), nil
+}
+
+// openV2 derives the key for a version 2 header and checks the nonce
+// prefix fits the cipher.
+func openV2(h *Header, header []byte, password []byte) (cipher.AEAD, layout, error) {
+	aead, err := h.newAEAD(password)
+	if err != nil {
+		return nil, layout{}, err
+	}
+	if len(h.Nonce) != aead.NonceSize()-8 {
+		return nil, layout{}, fmt.Errorf("%w: bad nonce size", ErrUnsupported)
+	}
+	return aead, newLayout(h, len(header), aead.Overhead()), nil
+}
+
+func openIndex(aead cipher.AEAD, h *Header, header []byte, l layout, sealed []byte) ([]byte, error) {
+	index, err := aead.Open(nil, chunkNonce(h.Nonce, l.chunks), sealed, header)
+	if err != nil || int64(len(index)) != l.chunks*sha256.Size {
+		return nil, ErrDecryptFailed
+	}
+	return index, nil
+}
+
+// DecryptStream decrypts a file of any supported version from r to w.
+// Version 2 files are processed one chunk at a time; every chunk is
+// authenticated before it is written, but a truncated or altered file is
+// only reported once the bad chunk is reached, so callers should discard
+// the output when an error is returned.
+func DecryptStream(w io.Writer, r io.Reader, password []byte) error {
+	h, header, err := ReadHeader(r)
+	if err != nil {
+		return err
+	}
+	if h.Version == formatV1 {
+		return decryptV1(w, h, header, r, password)
+	}
+	aead, l, err := openV2(h, header, password)
+	if err != nil {
+		return err
+	}
+
+	// Hash the chunk hashes as they go by rather than keeping them, and
+	// compare with the hash of the index at the end.
+	hashes := sha256.New()
+	sealed := make([]byte, l.chunkSize+l.overhead)
+	plain := make([]byte, 0, l.chunkSize)
+	for i := int64(0); i < l.chunks; i++ {
+		chunk := sealed[:l.plainLen(i)+l.overhead]
+		if _, err := io.ReadFull(r, chunk); err != nil {
+			return ErrDecryptFailed
+		}
+		plain, err = aead.Open(plain[:0], chunkNonce(h.Nonce, i), chunk, header)
+		if err != nil {
+			return ErrDecryptFailed
+		}
+		sum := sha256.Sum256(chunk)
+		hashes.Write(sum[:])
+		if _, err := w.Write(plain); err != nil {
+			return fmt.Errorf("failed to write output: %w", err)
+		}
+	}
+
+	sealedIndex := make([]byte, l.indexLen())
+	if _, err := io.ReadFull(r, sealedIndex); err != nil {
+		return ErrDecryptFailed
+	}
+	index, err := openIndex(aead, h, header, l, sealedIndex)
+	if err != nil {
+		return err
+	}
+	if sum := sha256.Sum256(index); !bytes.Equal(sum[:], hashes.Sum(nil)) {
+		return ErrDecryptFailed
+	}
+	if n, _ := io.ReadFull(r, make([]byte, 1)); n != 0 {
+		return ErrDecryptFailed
+	}
+	return nil
+}
+
+// readAt fills p from r at off, treating a full read that ends at EOF as
+// success.
+func readAt(r io.ReaderAt, p []byte, off int64) error {
+	n, err := r.ReadAt(p, off)
+	if n == len(p) {
+		return nil
+	}
+	if err == nil || errors.Is(err, io.EOF) {
+		err = io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// DecryptRange decrypts length bytes starting at offset of the plaintext
+// and writes them to w. It reads only the header, the index and the chunks
+// that cover the range, so with a ranged BlobStore the rest of the blob is
+// never downloaded. Each chunk is checked against the index and then
+// authenticated before any of it is written.
+func DecryptRange(w io.Writer, r io.ReaderAt, password []byte, offset, length int64) error {
+	if offset < 0 || length < 0 {
+		return errors.New("invalid range")
+	}
+
+	// Read the largest possible header in one go; a short read is fine as
+	// long as the header itself is complete.
+	buf := make([]byte, maxHeaderSize)
+	n, err := r.ReadAt(buf, 0)
+	if n == 0 && err != nil {
+		return fmt.Errorf("failed to read header: %w", err)
+	}
+	h, header, err := ReadHeader(bytes.NewReader(buf[:n]))
+	if err != nil {
+		return err
+	}
+	if h.Version == formatV1 {
+		return fmt.Errorf("%w: version 1 files can only be decrypted whole", ErrUnsupported)
+	}
+	aead, l, err := openV2(h, header, password)
+	if err != nil {
+		return err
+	}
+	if offset > l.size || length > l.size-offset {
+		return fmt.Errorf("range %d+%d is outside the %d byte file", offset, length, l.size)
+	}
+	if length == 0 {
+		return nil
+	}
+
+	sealedIndex := make([]byte, l.indexLen())
+	if err := readAt(r, sealedIndex, l.indexOffset()); err != nil {
+		return fmt.Errorf("failed to read index: %w", err)
+	}
+	index, err := openIndex(aead, h, header, l, sealedIndex)
+	if err != nil {
+		return err
+	}
+
+	first := offset / l.chunkSize
+	last := (offset + length - 1) / l.chunkSize
+	sealed := make([]byte, l.chunkOffset(last)+l.plainLen(last)+l.overhead-l.chunkOffset(first))
+	if err := readAt(r, sealed, l.chunkOffset(first)); err != nil {
+		return fmt.Errorf("failed to read chunks: %w", err)
+	}
+
+	var plain []byte
+	for i := first; i <= last; i++ {
+		start := l.chunkOffset(i) - l.chunkOffset(first)
+		chunk := sealed[start : start+l.plainLen(i)+l.overhead]
+		sum := sha256.Sum256(chunk)
+		if !bytes.Equal(sum[:], index[i*sha256.Size:(i+1)*sha256.Size]) {
+			return fmt.Errorf("%w: chunk %d does not match the index", ErrDecryptFailed, i)
+		}
+		plain, err = aead.Open(plain[:0], chunkNonce(h.Nonce, i), chunk, header)
+		if err != nil {
+			return ErrDecryptFailed
+		}
+
+		lo, hi := int64(0), int64(len(plain))
+		if i == first {
+			lo = offset - first*l.chunkSize
+		}
+		if i == last {
+			hi = offset + length - i*l.chunkSize
+		}
+		if _, err := w.Write(plain[lo:hi]); err != nil {
+			return fmt.Errorf("failed to write output: %w", err)
+		}
+	}
+	return nil
+}
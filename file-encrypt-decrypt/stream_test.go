@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math"
+	"testing"
+)
+
+// Headers are read before anything is authenticated, so sizes taken from a
+// crafted header must be rejected rather than used to size buffers.
+func TestDecryptRejectsOversizedHeader(t *testing.T) {
+	tests := []struct {
+		name      string
+		chunkSize uint32
+		size      uint64
+	}{
+		{"too many chunks", 1, 1 << 50},
+		{"size above MaxInt64", defaultChunkSize, math.MaxInt64 + 1},
+		{"max uint64 size", maxChunkSize, math.MaxUint64},
+		{"one chunk past the limit", 1, maxChunks + 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &Header{
+				Version:   formatV2,
+				KDF:       KDFArgon2id,
+				Params:    DefaultOptions.Params,
+				Salt:      make([]byte, saltSize),
+				Cipher:    CipherAES256GCM,
+				Nonce:     make([]byte, 4),
+				ChunkSize: tt.chunkSize,
+				Size:      tt.size,
+			}
+			header, err := h.MarshalBinary()
+			if err != nil {
+				t.Fatal(err)
+			}
+			data := append(header, make([]byte, 64)...)
+
+			if _, err := Decrypt(data, []byte("password")); !errors.Is(err, ErrUnsupported) {
+				t.Errorf("Decrypt error = %v, want ErrUnsupported", err)
+			}
+			err = DecryptRange(io.Discard, bytes.NewReader(data), []byte("password"), 0, 1)
+			if !errors.Is(err, ErrUnsupported) {
+				t.Errorf("DecryptRange error = %v, want ErrUnsupported", err)
+			}
+		})
+	}
+}
+
+func TestDecryptStreamRoundTrip(t *testing.T) {
+	opts := ScryptOptions
+	opts.Params = [3]uint32{1 << 10, 8, 1}
+	opts.ChunkSize = 16
+	plaintext := bytes.Repeat([]byte("0123456789"), 10)
+
+	data, err := Encrypt(plaintext, []byte("password"), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := Decrypt(data, []byte("password"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, plaintext) {
+		t.Fatalf("Decrypt = %q, want %q", got, plaintext)
+	}
+
+	data[len(data)-1] ^= 1
+	if _, err := Decrypt(data, []byte("password")); !errors.Is(err, ErrDecryptFailed) {
+		t.Fatalf("Decrypt of tampered index error = %v, want ErrDecryptFailed", err)
+	}
+}
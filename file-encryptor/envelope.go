@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// Files encrypted for a recipient start with a header holding the data key
+// wrapped with the recipient's RSA public key:
+//
+//	magic    "FENV"
+//	version  1 byte
+//	key len  uint16 big endian
+//	key      wrapped data key, as returned by encryptWithRSA
+//	nonce    GCM nonce
+//
+// followed by the AES-256-GCM ciphertext. The header is the GCM additional
+// data, so it cannot be altered without failing decryption. Password mode
+// files have no header and begin with their random salt instead.
+var envelopeMagic = []byte("FENV")
+
+const envelopeVersion = 1
+
+func readPEMFile(path string) ([]byte, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key file: %w", err)
+	}
+	return data, nil
+}
+
+// generateKeyFiles writes a new RSA key pair as PEM. Existing files are
+// never overwritten, and the private key is readable only by its owner.
+func generateKeyFiles(privatePath, publicPath string) error {
+	privateKey, publicKey, err := generateRSAKeys()
+	if err != nil {
+		return fmt.Errorf("failed to generate key pair: %w", err)
+	}
+	privatePEM, err := exportRSAPrivateKey(privateKey)
+	if err != nil {
+		return err
+	}
+	publicPEM, err := exportRSAPublicKey(publicKey)
+	if err != nil {
+		return err
+	}
+
+	if err := writeNewFile(privatePath, privatePEM, 0600); err != nil {
+		return err
+	}
+	if err := writeNewFile(publicPath, publicPEM, 0644); err != nil {
+		os.Remove(privatePath)
+		return err
+	}
+	return nil
+}
+
+func writeNewFile(path string, data []byte, perm os.FileMode) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(path)
+		return err
+	}
+	return f.Close()
+}
+
+// encryptFileForRecipient encrypts filePath with a random AES-256 key and
+// wraps that key for the holder of the private key matching publicKeyPath.
+func encryptFileForRecipient(filePath, publicKeyPath string) error {
+	publicKeyPEM, err := readPEMFile(publicKeyPath)
+	if err != nil {
+		return err
+	}
+	publicKey, err := importRSAPublicKey(publicKeyPEM)
+	if err != nil {
+		return err
+	}
+	plaintext, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return err
+	}
+
+	dataKey := make([]byte, keySize)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return err
+	}
+	wrappedKey, err := encryptWithRSA(publicKey, dataKey)
+	if err != nil {
+		return fmt.Errorf("failed to wrap data key: %w", err)
+	}
+
+	gcm, err := newGCM(dataKey)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return err
+	}
+
+	var header bytes.Buffer
+	header.Write(envelopeMagic)
+	header.WriteByte(envelopeVersion)
+	binary.Write(&header, binary.BigEndian, uint16(len(wrappedKey)))
+	header.WriteString(wrappedKey)
+	header.Write(nonce)
+
+	result := gcm.Seal(header.Bytes(), nonce, plaintext, header.Bytes())
+	return ioutil.WriteFile(filePath+".enc", result, 0644)
+}
+
+// decryptFileWithIdentity reverses encryptFileForRecipient using the
+// private key in privateKeyPath.
+func decryptFileWithIdentity(filePath, privateKeyPath string) error {
+	privateKeyPEM, err := readPEMFile(privateKeyPath)
+	if err != nil {
+		return err
+	}
+	privateKey, err := importRSAPrivateKey(privateKeyPEM)
+	if err != nil {
+		return err
+	}
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return err
+	}
+
+	plaintext, err := openEnvelope(data, privateKey)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filePath+".dec", plaintext, 0644)
+}
+
+func openEnvelope(data []byte, privateKey *rsa.PrivateKey) ([]byte, error) {
+	prefix := len(envelopeMagic) + 1 + 2
+	if len(data) < prefix || !bytes.Equal(data[:len(envelopeMagic)], envelopeMagic) {
+		return nil, errors.New("file was not encrypted for a recipient")
+	}
+	if version := data[len(envelopeMagic)]; version != envelopeVersion {
+		return nil, fmt.Errorf("unsupported envelope version %d", version)
+	}
+	keyLen := int(binary.BigEndian.Uint16(data[len(envelopeMagic)+1:]))
+	if len(data) < prefix+keyLen {
+		return nil, errors.New("envelope header is truncated")
+	}
+	wrappedKey := string(data[prefix : prefix+keyLen])
+
+	dataKey, err := decryptWithRSA(privateKey, wrappedKey)
+	if err != nil {
+		return nil, errors.New("failed to unwrap data key: file was not encrypted for this identity")
+	}
+	gcm, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	headerLen := prefix + keyLen + gcm.NonceSize()
+	if len(data) < headerLen {
+		return nil, errors.New("envelope header is truncated")
+	}
+	header := data[:headerLen]
+	nonce := header[prefix+keyLen:]
+	return gcm.Open(nil, nonce, data[headerLen:], header)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	if len(key) != keySize {
+		return nil, fmt.Errorf("data key has %d bytes, want %d", len(key), keySize)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
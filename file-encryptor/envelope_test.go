@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestEnvelopeRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	privatePath := filepath.Join(dir, "private.pem")
+	publicPath := filepath.Join(dir, "public.pem")
+	if err := generateKeyFiles(privatePath, publicPath); err != nil {
+		t.Fatal(err)
+	}
+	plaintext := []byte("attack at dawn")
+	filePath := filepath.Join(dir, "secret.txt")
+	if err := os.WriteFile(filePath, plaintext, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := encryptFileForRecipient(filePath, publicPath); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filePath + ".enc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	privateKeyPEM, err := readPEMFile(privatePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	privateKey, err := importRSAPrivateKey(privateKeyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := openEnvelope(data, privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, plaintext) {
+		t.Fatalf("openEnvelope = %q, want %q", got, plaintext)
+	}
+
+	// Flip the first nonce byte. The nonce is part of the header, which is
+	// authenticated as GCM additional data.
+	prefix := len(envelopeMagic) + 1 + 2
+	keyLen := int(binary.BigEndian.Uint16(data[len(envelopeMagic)+1:]))
+	tampered := append([]byte(nil), data...)
+	tampered[prefix+keyLen] ^= 1
+	if _, err := openEnvelope(tampered, privateKey); err == nil {
+		t.Error("openEnvelope accepted a tampered header")
+	}
+
+	tampered = append([]byte(nil), data...)
+	tampered[len(tampered)-1] ^= 1
+	if _, err := openEnvelope(tampered, privateKey); err == nil {
+		t.Error("openEnvelope accepted tampered ciphertext")
+	}
+
+	other, _, err := generateRSAKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := openEnvelope(data, other); err == nil {
+		t.Error("openEnvelope succeeded with the wrong identity")
+	}
+}
+
+// Keys produced by openssl and by older versions of this tool must keep
+// importing.
+func TestImportKeyFormats(t *testing.T) {
+	privateKey, publicKey, err := generateRSAKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkix, err := x509.MarshalPKIXPublicKey(publicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	privateBlocks := []*pem.Block{
+		{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(privateKey)},
+		{Type: "PRIVATE KEY", Bytes: pkcs8},
+	}
+	for _, block := range privateBlocks {
+		got, err := importRSAPrivateKey(pem.EncodeToMemory(block))
+		if err != nil {
+			t.Errorf("%s: %v", block.Type, err)
+			continue
+		}
+		if !got.Equal(privateKey) {
+			t.Errorf("%s: imported a different key", block.Type)
+		}
+	}
+
+	publicBlocks := []*pem.Block{
+		{Type: "PUBLIC KEY", Bytes: pkix},
+		{Type: "RSA PUBLIC KEY", Bytes: pkix},
+		{Type: "RSA PUBLIC KEY", Bytes: x509.MarshalPKCS1PublicKey(publicKey)},
+	}
+	for _, block := range publicBlocks {
+		got, err := importRSAPublicKey(pem.EncodeToMemory(block))
+		if err != nil {
+			t.Errorf("%s: %v", block.Type, err)
+			continue
+		}
+		if !got.Equal(publicKey) {
+			t.Errorf("%s: imported a different key", block.Type)
+		}
+	}
+
+	exported, err := exportRSAPublicKey(publicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if block, _ := pem.Decode(exported); block == nil || block.Type != "PUBLIC KEY" {
+		t.Errorf("exported public key is not a PUBLIC KEY block")
+	}
+}
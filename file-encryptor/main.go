@@ -14,6 +14,7 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"os"
 
 	"golang.org/x/crypto/pbkdf2"
 )
@@ -25,33 +26,67 @@ const (
 )
 
 func main() {
-	action := flag.String("action", "encrypt", "Action to perform: encrypt or decrypt")
+	action := flag.String("action", "encrypt", "Action to perform: encrypt, decrypt or keygen")
 	file := flag.String("file", "", "File to encrypt or decrypt")
 	password := flag.String("password", "", "Password for encryption or decryption")
+	recipient := flag.String("recipient", "", "Encrypt for the holder of this RSA public key (PEM) instead of a password")
+	identity := flag.String("identity", "", "Decrypt with this RSA private key (PEM) instead of a password")
+	privateOut := flag.String("private", "private.pem", "Where keygen writes the private key")
+	publicOut := flag.String("public", "public.pem", "Where keygen writes the public key")
 	flag.Parse()
 
-	if *file == "" || *password == "" {
-		fmt.Println("File and password must be provided")
+	if *action == "keygen" {
+		err := generateKeyFiles(*privateOut, *publicOut)
+		if err != nil {
+			fmt.Printf("Key generation failed: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Key pair written to %s and %s\n", *privateOut, *publicOut)
 		return
 	}
 
+	// A password next to a key would be silently ignored, leaving the user
+	// believing the file is password protected.
+	if *password != "" && (*recipient != "" || *identity != "") {
+		fmt.Fprintln(os.Stderr, "Use either -password or -recipient/-identity, not both")
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	keyed := (*action == "encrypt" && *recipient != "") || (*action == "decrypt" && *identity != "")
+	if *file == "" || (*password == "" && !keyed) {
+		fmt.Println("File and password (or -recipient/-identity) must be provided")
+		os.Exit(2)
+	}
+
 	switch *action {
 	case "encrypt":
-		err := encryptFile(*file, *password)
+		var err error
+		if *recipient != "" {
+			err = encryptFileForRecipient(*file, *recipient)
+		} else {
+			err = encryptFile(*file, *password)
+		}
 		if err != nil {
 			fmt.Printf("Encryption failed: %v\n", err)
-		} else {
-			fmt.Println("File encrypted successfully")
+			os.Exit(1)
 		}
+		fmt.Println("File encrypted successfully")
 	case "decrypt":
-		err := decryptFile(*file, *password)
+		var err error
+		if *identity != "" {
+			err = decryptFileWithIdentity(*file, *identity)
+		} else {
+			err = decryptFile(*file, *password)
+		}
 		if err != nil {
 			fmt.Printf("Decryption failed: %v\n", err)
-		} else {
-			fmt.Println("File decrypted successfully")
+			os.Exit(1)
 		}
+		fmt.Println("File decrypted successfully")
 	default:
 		fmt.Println("Unknown action:", *action)
+		os.Exit(2)
 	}
 }
 
@@ -153,29 +188,53 @@ func exportRSAPublicKey(publicKey *rsa.PublicKey) ([]byte, error) {
 		return nil, err
 	}
 	publicKeyPEM := pem.EncodeToMemory(&pem.Block{
-		Type:  "RSA PUBLIC KEY",
+		Type:  "PUBLIC KEY",
 		Bytes: publicKeyBytes,
 	})
 	return publicKeyPEM, nil
 }
 
+// importRSAPrivateKey accepts PKCS#1 ("RSA PRIVATE KEY") and PKCS#8
+// ("PRIVATE KEY") encodings, so keys made by openssl work as well as ours.
 func importRSAPrivateKey(privateKeyPEM []byte) (*rsa.PrivateKey, error) {
 	block, _ := pem.Decode(privateKeyPEM)
-	if block == nil || block.Type != "RSA PRIVATE KEY" {
+	if block == nil {
 		return nil, errors.New("failed to decode PEM block containing private key")
 	}
-	privateKey, err := x509.ParsePKCS1PrivateKey(block.Bytes)
-	if err != nil {
-		return nil, err
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		privateKey, ok := key.(*rsa.PrivateKey)
+		if !ok {
+			return nil, errors.New("key is not of type RSA private key")
+		}
+		return privateKey, nil
 	}
-	return privateKey, nil
+	return nil, fmt.Errorf("unsupported private key PEM type %q", block.Type)
 }
 
+// importRSAPublicKey accepts PKIX ("PUBLIC KEY") and PKCS#1 ("RSA PUBLIC
+// KEY") encodings. Older versions of this tool wrote PKIX bytes under the
+// PKCS#1 label, so that label falls back to PKIX.
 func importRSAPublicKey(publicKeyPEM []byte) (*rsa.PublicKey, error) {
 	block, _ := pem.Decode(publicKeyPEM)
-	if block == nil || block.Type != "RSA PUBLIC KEY" {
+	if block == nil {
 		return nil, errors.New("failed to decode PEM block containing public key")
 	}
+	switch block.Type {
+	case "PUBLIC KEY":
+	case "RSA PUBLIC KEY":
+		if publicKey, err := x509.ParsePKCS1PublicKey(block.Bytes); err == nil {
+			return publicKey, nil
+		}
+	default:
+		return nil, fmt.Errorf("unsupported public key PEM type %q", block.Type)
+	}
 	publicKey, err := x509.ParsePKIXPublicKey(block.Bytes)
 	if err != nil {
 		return nil, err
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Checkpoint is the on-disk record of a scan in progress. Hosts are tracked
+// by their position in the target walk: every host before NextHost has been
+// fully scanned, and Done lists finished hosts beyond that watermark.
+type Checkpoint struct {
+	Targets    []string      `json:"targets"`
+	Exclude    []string      `json:"exclude,omitempty"`
+	Ports      []int         `json:"ports"`
+	ListClosed bool          `json:"list_closed,omitempty"`
+	NextHost   int           `json:"next_host"`
+	Done       []int         `json:"done,omitempty"`
+	Hosts      []HostSummary `json:"hosts,omitempty"`
+	Updated    time.Time     `json:"updated"`
+}
+
+// loadCheckpoint reads a checkpoint written by a previous run.
+func loadCheckpoint(path string) (*Checkpoint, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cp Checkpoint
+	if err := json.Unmarshal(data, &cp); err != nil {
+		return nil, fmt.Errorf("invalid checkpoint %s: %w", path, err)
+	}
+	if len(cp.Targets) == 0 || len(cp.Ports) == 0 {
+		return nil, fmt.Errorf("checkpoint %s has no targets or ports", path)
+	}
+	return &cp, nil
+}
+
+// save writes the checkpoint atomically so a crash mid-write never leaves a
+// truncated file behind.
+func (cp *Checkpoint) save(path string) error {
+	cp.Updated = time.Now()
+	data, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// completed returns a predicate reporting whether the host at a given walk
+// index was finished by an earlier run. It works on a snapshot, so it is safe
+// to call while a Tracker keeps updating the checkpoint.
+func (cp *Checkpoint) completed() func(index int) bool {
+	next := cp.NextHost
+	done := make(map[int]bool, len(cp.Done))
+	for _, i := range cp.Done {
+		done[i] = true
+	}
+	return func(index int) bool {
+		return index < next || done[index]
+	}
+}
+
+// Tracker sits between the scanner and processResults, recording which hosts
+// are complete and periodically flushing that progress to a checkpoint file.
+type Tracker struct {
+	path     string
+	interval time.Duration
+	cp       *Checkpoint
+
+	remaining map[int]int
+	hosts     map[int]*HostSummary
+	done      map[int]bool
+}
+
+func newTracker(path string, interval time.Duration, cp *Checkpoint) *Tracker {
+	t := &Tracker{
+		path:      path,
+		interval:  interval,
+		cp:        cp,
+		remaining: make(map[int]int),
+		hosts:     make(map[int]*HostSummary),
+		done:      make(map[int]bool),
+	}
+	for _, i := range cp.Done {
+		t.done[i] = true
+	}
+	return t
+}
+
+// Track forwards every result unchanged, saving progress every interval and
+// once more when the input is drained.
+func (t *Tracker) Track(results <-chan ScanResult) <-chan ScanResult {
+	out := make(chan ScanResult)
+	go func() {
+		defer close(out)
+		ticker := time.NewTicker(t.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case r, ok := <-results:
+				if !ok {
+					t.flush()
+					return
+				}
+				t.record(r)
+				out <- r
+			case <-ticker.C:
+				t.flush()
+			}
+		}
+	}()
+	return out
+}
+
+// record counts a result against its host. A host's summary is only
+// committed to the checkpoint once the whole host is done, because a
+// partially scanned host is scanned again from scratch on resume.
+func (t *Tracker) record(r ScanResult) {
+	summary, ok := t.hosts[r.host]
+	if !ok {
+		summary = &HostSummary{IP: r.IP}
+		t.hosts[r.host] = summary
+	}
+	summary.add(r, t.cp.ListClosed)
+
+	left, ok := t.remaining[r.host]
+	if !ok {
+		left = len(t.cp.Ports)
+	}
+	left--
+	if left > 0 {
+		t.remaining[r.host] = left
+		return
+	}
+
+	delete(t.remaining, r.host)
+	t.cp.Hosts = append(t.cp.Hosts, *summary)
+	delete(t.hosts, r.host)
+	t.done[r.host] = true
+	for t.done[t.cp.NextHost] {
+		delete(t.done, t.cp.NextHost)
+		t.cp.NextHost++
+	}
+}
+
+func (t *Tracker) flush() {
+	t.cp.Done = t.cp.Done[:0]
+	for i := range t.done {
+		t.cp.Done = append(t.cp.Done, i)
+	}
+	if err := t.cp.save(t.path); err != nil {
+		log.Printf("Failed to save checkpoint: %v", err)
+	}
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/rodaine/table"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// HostStatus is the outcome of pinging a single host during discovery.
+type HostStatus struct {
+	IP     string
+	Up     bool
+	Method string
+	RTT    time.Duration
+}
+
+// Discoverer finds live hosts with TCP connect pings and, when the process is
+// allowed to open ICMP sockets, ICMP echo requests.
+type Discoverer struct {
+	Ports       []int
+	Timeout     time.Duration
+	Concurrency int
+	Rate        int
+	ICMP        bool
+}
+
+// Run pings every target and returns the hosts that answered, sorted by IP.
+// Cancelling ctx stops handing out new hosts.
+func (d *Discoverer) Run(ctx context.Context, targets *TargetList) []HostStatus {
+	hosts := make(chan string)
+	go func() {
+		defer close(hosts)
+		targets.Walk(func(_ int, ip string) bool {
+			select {
+			case hosts <- ip:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		})
+	}()
+
+	var tick <-chan time.Time
+	if d.Rate > 0 {
+		ticker := time.NewTicker(time.Second / time.Duration(d.Rate))
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	var (
+		mu   sync.Mutex
+		live []HostStatus
+		wg   sync.WaitGroup
+	)
+	for i := 0; i < d.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ip := range hosts {
+				if tick != nil {
+					<-tick
+				}
+				status := d.ping(ip)
+				if status.Up {
+					mu.Lock()
+					live = append(live, status)
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	sort.Slice(live, func(i, j int) bool {
+		return compareIP(live[i].IP, live[j].IP) < 0
+	})
+	return live
+}
+
+// ping races the TCP pings and the optional ICMP echo; the first answer wins.
+func (d *Discoverer) ping(ip string) HostStatus {
+	ctx, cancel := context.WithTimeout(context.Background(), d.Timeout)
+	defer cancel()
+
+	answers := make(chan HostStatus, len(d.Ports)+1)
+	pending := 0
+	for _, port := range d.Ports {
+		pending++
+		go func(port int) {
+			answers <- tcpPing(ctx, ip, port)
+		}(port)
+	}
+	if d.ICMP {
+		pending++
+		go func() {
+			answers <- icmpPing(ctx, ip)
+		}()
+	}
+
+	for ; pending > 0; pending-- {
+		if status := <-answers; status.Up {
+			return status
+		}
+	}
+	return HostStatus{IP: ip}
+}
+
+func tcpPing(ctx context.Context, ip string, port int) HostStatus {
+	status := HostStatus{IP: ip, Method: fmt.Sprintf("tcp/%d", port)}
+	var dialer net.Dialer
+	start := time.Now()
+	conn, err := dialer.DialContext(ctx, protocol, net.JoinHostPort(ip, fmt.Sprint(port)))
+	status.RTT = time.Since(start)
+	if err == nil {
+		conn.Close()
+		status.Up = true
+	} else if errors.Is(err, syscall.ECONNREFUSED) {
+		// A reset still means something answered.
+		status.Up = true
+	}
+	return status
+}
+
+// icmpAvailable reports whether an ICMP socket can be opened, either a raw
+// one (root or CAP_NET_RAW) or an unprivileged datagram one.
+func icmpAvailable() bool {
+	for _, network := range []string{"ip4:icmp", "udp4"} {
+		if conn, err := icmp.ListenPacket(network, ""); err == nil {
+			conn.Close()
+			return true
+		}
+	}
+	return false
+}
+
+func icmpPing(ctx context.Context, ip string) HostStatus {
+	status := HostStatus{IP: ip, Method: "icmp"}
+	dst := net.ParseIP(ip)
+	if dst == nil {
+		return status
+	}
+
+	v4 := dst.To4() != nil
+	networks := []string{"ip6:ipv6-icmp", "udp6"}
+	var echoType, replyType icmp.Type = ipv6.ICMPTypeEchoRequest, ipv6.ICMPTypeEchoReply
+	proto := 58
+	if v4 {
+		networks = []string{"ip4:icmp", "udp4"}
+		echoType, replyType = ipv4.ICMPTypeEcho, ipv4.ICMPTypeEchoReply
+		proto = 1
+	}
+
+	var conn *icmp.PacketConn
+	var err error
+	var addr net.Addr
+	for _, network := range networks {
+		conn, err = icmp.ListenPacket(network, "")
+		if err == nil {
+			addr = &net.IPAddr{IP: dst}
+			if strings.HasPrefix(network, "udp") {
+				addr = &net.UDPAddr{IP: dst}
+			}
+			break
+		}
+	}
+	if err != nil {
+		return status
+	}
+	defer conn.Close()
+
+	id := os.Getpid() & 0xffff
+	msg := icmp.Message{
+		Type: echoType,
+		Body: &icmp.Echo{ID: id, Seq: 1, Data: []byte("network-scanner")},
+	}
+	data, err := msg.Marshal(nil)
+	if err != nil {
+		return status
+	}
+
+	deadline, _ := ctx.Deadline()
+	conn.SetDeadline(deadline)
+
+	start := time.Now()
+	if _, err := conn.WriteTo(data, addr); err != nil {
+		return status
+	}
+
+	buf := make([]byte, 1500)
+	for {
+		n, peer, err := conn.ReadFrom(buf)
+		if err != nil {
+			return status
+		}
+		if !sameHost(peer, dst) {
+			continue
+		}
+		reply, err := icmp.ParseMessage(proto, buf[:n])
+		if err != nil || reply.Type != replyType {
+			continue
+		}
+		status.RTT = time.Since(start)
+		status.Up = true
+		return status
+	}
+}
+
+func sameHost(peer net.Addr, ip net.IP) bool {
+	switch a := peer.(type) {
+	case *net.IPAddr:
+		return a.IP.Equal(ip)
+	case *net.UDPAddr:
+		return a.IP.Equal(ip)
+	}
+	return false
+}
+
+func compareIP(a, b string) int {
+	addrA, errA := netip.ParseAddr(a)
+	addrB, errB := netip.ParseAddr(b)
+	if errA != nil || errB != nil {
+		return strings.Compare(a, b)
+	}
+	return addrA.Compare(addrB)
+}
+
+func printLiveHosts(hosts []HostStatus) {
+	tbl := table.New("IP Address", "Method", "Response Time")
+	for _, h := range hosts {
+		tbl.AddRow(h.IP, h.Method, h.RTT.Round(time.Microsecond))
+	}
+	tbl.Print()
+	fmt.Printf("%d host(s) up\n\n", len(hosts))
+}
@@ -1,112 +1,257 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
+	"io"
 	"log"
-	"net"
-	"sort"
+	"os"
+	"os/signal"
 	"strings"
-	"sync"
+	"syscall"
 	"time"
-
-	"github.com/rodaine/table"
 )
 
+const defaultNetwork = "192.168.1.0/24"
+
+// maxRate is the highest -rate accepted. Faster than this the ticker
+// interval stops meaning anything, so use 0 for unlimited instead.
+const maxRate = 1000000
+
 var (
 	timeout  time.Duration = 1 * time.Second
 	protocol string        = "tcp"
 )
 
 type ScanResult struct {
-	IP   string
-	Port int
-	Open bool
-	Err  error
+	IP    string
+	Port  int
+	State PortState
+	Err   error
+	host  int
 }
 
 func main() {
-	network := flag.String("network", "192.168.1.0/24", "Network range to scan in CIDR notation")
+	network := flag.String("network", "", "Comma-separated targets: CIDRs (IPv4 or IPv6), ranges, addresses or hostnames (default "+defaultNetwork+")")
+	targetsFile := flag.String("iL", "", "Read targets from a file, one or more per line")
+	exclude := flag.String("exclude", "", "Comma-separated targets to skip")
+	allowLarge := flag.Bool("allow-large", false, "Allow targets larger than an IPv4 /8 or an IPv6 /112")
+	portSpec := flag.String("ports", "1-1024", "Ports to scan, e.g. 22,80,8000-8100")
+	top := flag.Int("top-ports", 0, "Scan the N most common ports instead of -ports")
+	concurrency := flag.Int("concurrency", 256, "Number of concurrent probe workers")
+	rate := flag.Int("rate", 0, "Maximum probes per second, up to 1000000 (0 for unlimited)")
+	skipDiscovery := flag.Bool("Pn", false, "Skip host discovery and treat every target as up")
+	pingPorts := flag.String("ping-ports", "80,443,22,3389", "TCP ports used for host discovery")
+	checkpointPath := flag.String("checkpoint", "network-scanner.checkpoint", "File used to save scan progress")
+	checkpointInterval := flag.Duration("checkpoint-interval", 30*time.Second, "How often to save scan progress")
+	resume := flag.Bool("resume", false, "Resume the scan saved in the checkpoint file")
+	format := flag.String("format", "table", "Output format: table, json or csv")
+	listClosed := flag.Bool("closed", false, "List closed ports individually in json and csv output")
+	output := flag.String("o", "", "Write results to this file instead of stdout")
 	flag.Parse()
 
-	ips, err := generateIPList(*network)
-	if err != nil {
-		log.Fatalf("Failed to generate IP list: %v", err)
-	}
-
-	var wg sync.WaitGroup
-	results := make(chan ScanResult, len(ips)*1024)
-
-	for _, ip := range ips {
-		for port := 1; port <= 1024; port++ {
-			wg.Add(1)
-			go func(ip string, port int) {
-				defer wg.Done()
-				result := ScanResult{IP: ip, Port: port}
-				conn, err := net.DialTimeout(protocol, fmt.Sprintf("%s:%d", ip, port), timeout)
-				if err != nil {
-					result.Open = false
-					result.Err = err
-				} else {
-					result.Open = true
-					conn.Close()
-				}
-				results <- result
-			}(ip, port)
+	if *concurrency < 1 {
+		log.Fatalf("Concurrency must be at least 1")
+	}
+	if *rate < 0 || *rate > maxRate {
+		log.Fatalf("Rate must be between 0 (unlimited) and %d", maxRate)
+	}
+	switch *format {
+	case "table", "json", "csv":
+	default:
+		log.Fatalf("Unknown output format %q", *format)
+	}
+
+	out := os.Stdout
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			log.Fatalf("Failed to create output file: %v", err)
+		}
+		defer f.Close()
+		out = f
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var (
+		targets *TargetList
+		ports   []int
+		cp      *Checkpoint
+		err     error
+	)
+	if *resume {
+		cp, err = loadCheckpoint(*checkpointPath)
+		if err != nil {
+			log.Fatalf("Failed to load checkpoint: %v", err)
 		}
+		targets, err = restoreTargets(cp)
+		if err != nil {
+			log.Fatalf("Failed to restore targets: %v", err)
+		}
+		ports = cp.Ports
+		log.Printf("Resuming scan from %s with %d host(s) already done", *checkpointPath, len(cp.Hosts))
+	} else {
+		targets, err = buildTargets(*network, *targetsFile, *exclude, flag.Args(), *allowLarge)
+		if err != nil {
+			log.Fatalf("Failed to parse targets: %v", err)
+		}
+
+		if *top > 0 {
+			ports, err = mostCommonPorts(*top)
+		} else {
+			ports, err = parsePorts(*portSpec)
+		}
+		if err != nil {
+			log.Fatalf("Failed to parse ports: %v", err)
+		}
+
+		if !*skipDiscovery {
+			targets, err = discoverHosts(ctx, targets, *pingPorts, *concurrency, *rate)
+			if err != nil {
+				log.Fatalf("Host discovery failed: %v", err)
+			}
+			if ctx.Err() != nil {
+				fmt.Println("Interrupted during host discovery")
+				return
+			}
+			if targets.Empty() {
+				fmt.Println("No live hosts found")
+				return
+			}
+		}
+
+		cp = &Checkpoint{Targets: targets.Specs(), Exclude: targets.Excludes(), Ports: ports, ListClosed: *listClosed}
+	}
+
+	scanner := &Scanner{
+		Concurrency: *concurrency,
+		Rate:        *rate,
+		Timeout:     timeout,
+	}
+	// The tracker appends to cp.Hosts as hosts finish, so take the hosts
+	// from the earlier run before it starts.
+	previous := append([]HostSummary(nil), cp.Hosts...)
+	probes := generateProbes(ctx, targets, ports, cp.completed())
+	tracker := newTracker(*checkpointPath, *checkpointInterval, cp)
+	results := tracker.Track(scanner.Run(probes))
+
+	if err := processResults(results, previous, cp.ListClosed, *format, out); err != nil {
+		log.Printf("Failed to write results: %v", err)
 	}
 
-	go func() {
-		wg.Wait()
-		close(results)
-	}()
+	if ctx.Err() != nil {
+		log.Printf("Scan interrupted; continue it with -resume -checkpoint %s", *checkpointPath)
+		return
+	}
+	if err := os.Remove(*checkpointPath); err != nil && !os.IsNotExist(err) {
+		log.Printf("Failed to remove checkpoint: %v", err)
+	}
+}
+
+func buildTargets(network, targetsFile, exclude string, args []string, allowLarge bool) (*TargetList, error) {
+	targets := &TargetList{AllowLarge: allowLarge}
+	specs := append(splitList(network), args...)
+	for _, spec := range specs {
+		if err := targets.AddSpec(spec); err != nil {
+			return nil, err
+		}
+	}
+	if targetsFile != "" {
+		if err := targets.AddFile(targetsFile); err != nil {
+			return nil, err
+		}
+	}
+	if targets.Empty() {
+		if err := targets.AddSpec(defaultNetwork); err != nil {
+			return nil, err
+		}
+	}
+	for _, spec := range splitList(exclude) {
+		if err := targets.Exclude(spec); err != nil {
+			return nil, err
+		}
+	}
+	return targets, nil
+}
 
-	processResults(results)
+// restoreTargets rebuilds the target list recorded in a checkpoint. The
+// checkpoint holds resolved address ranges rather than hostnames, so the walk
+// and the host indexes recorded against it match the original run. Sizes
+// were checked when the scan started, so they are not limited here.
+func restoreTargets(cp *Checkpoint) (*TargetList, error) {
+	targets := &TargetList{AllowLarge: true}
+	for _, spec := range cp.Targets {
+		if err := targets.AddSpec(spec); err != nil {
+			return nil, err
+		}
+	}
+	for _, spec := range cp.Exclude {
+		if err := targets.Exclude(spec); err != nil {
+			return nil, err
+		}
+	}
+	return targets, nil
 }
 
-func generateIPList(cidr string) ([]string, error) {
-	ip, ipnet, err := net.ParseCIDR(cidr)
+// discoverHosts pings every target and returns only the hosts that answered,
+// printing them as it goes.
+func discoverHosts(ctx context.Context, targets *TargetList, pingPorts string, concurrency, rate int) (*TargetList, error) {
+	ports, err := parsePorts(pingPorts)
 	if err != nil {
 		return nil, err
 	}
 
-	var ips []string
-	for ip := ip.Mask(ipnet.Mask); ipnet.Contains(ip); inc(ip) {
-		ips = append(ips, ip.String())
+	discoverer := &Discoverer{
+		Ports:       ports,
+		Timeout:     timeout,
+		Concurrency: concurrency,
+		Rate:        rate,
+		ICMP:        icmpAvailable(),
 	}
-	return ips[1 : len(ips)-1], nil
-}
+	if !discoverer.ICMP {
+		log.Printf("ICMP sockets unavailable, discovering hosts with TCP only")
+	}
+
+	live := discoverer.Run(ctx, targets)
+	printLiveHosts(live)
 
-func inc(ip net.IP) {
-	for j := len(ip) - 1; j >= 0; j-- {
-		ip[j]++
-		if ip[j] > 0 {
-			break
+	up := &TargetList{}
+	for _, host := range live {
+		if err := up.AddSpec(host.IP); err != nil {
+			return nil, err
 		}
 	}
+	return up, nil
 }
 
-func processResults(results chan ScanResult) {
-	openPorts := make(map[string][]int)
-	for result := range results {
-		if result.Open {
-			openPorts[result.IP] = append(openPorts[result.IP], result.Port)
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
 		}
 	}
+	return items
+}
 
-	// Sort IPs
-	ips := make([]string, 0, len(openPorts))
-	for ip := range openPorts {
-		ips = append(ips, ip)
+// processResults aggregates every probe, together with hosts finished by an
+// earlier run, and writes the report in the requested format.
+func processResults(results <-chan ScanResult, previous []HostSummary, listClosed bool, format string, w io.Writer) error {
+	report := newReport(listClosed)
+	for _, summary := range previous {
+		report.Merge(summary)
+	}
+	for result := range results {
+		report.Add(result)
 	}
-	sort.Strings(ips)
 
-	// Display results in a table format
-	tbl := table.New("IP Address", "Open Ports")
-	for _, ip := range ips {
-		ports := openPorts[ip]
-		sort.Ints(ports)
-		tbl.AddRow(ip, strings.Trim(strings.Replace(fmt.Sprint(ports), " ", ", ", -1), "[]"))
+	if format != "table" {
+		total := report.Totals()
+		log.Printf("%d host(s) scanned: %d open, %d closed, %d filtered",
+			len(report.hosts), total.Open, total.Closed, total.Filtered)
 	}
-	tbl.Print()
+	return report.Write(w, format)
 }
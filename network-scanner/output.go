@@ -0,0 +1,218 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/rodaine/table"
+)
+
+// PortState is the classification of a single probe.
+type PortState string
+
+const (
+	StateOpen     PortState = "open"
+	StateClosed   PortState = "closed"
+	StateFiltered PortState = "filtered"
+)
+
+// classify maps a dial error to a port state: a refused connection means the
+// host answered with a reset, anything else (timeouts, unreachable errors)
+// means the probe was dropped somewhere along the way.
+func classify(err error) PortState {
+	if err == nil {
+		return StateOpen
+	}
+	if errors.Is(err, syscall.ECONNREFUSED) {
+		return StateClosed
+	}
+	return StateFiltered
+}
+
+// HostSummary aggregates every probe sent to a single host. Open and filtered
+// ports are listed individually; closed ports, usually the bulk of a scan,
+// are only counted unless listClosed is passed to add.
+type HostSummary struct {
+	IP          string `json:"ip"`
+	Open        []int  `json:"open"`
+	Filtered    []int  `json:"filtered"`
+	Closed      int    `json:"closed"`
+	ClosedPorts []int  `json:"closed_ports,omitempty"`
+}
+
+func (h *HostSummary) add(r ScanResult, listClosed bool) {
+	switch r.State {
+	case StateOpen:
+		h.Open = append(h.Open, r.Port)
+	case StateFiltered:
+		h.Filtered = append(h.Filtered, r.Port)
+	default:
+		h.Closed++
+		if listClosed {
+			h.ClosedPorts = append(h.ClosedPorts, r.Port)
+		}
+	}
+}
+
+func (h *HostSummary) merge(other HostSummary) {
+	h.Open = append(h.Open, other.Open...)
+	h.Filtered = append(h.Filtered, other.Filtered...)
+	h.Closed += other.Closed
+	h.ClosedPorts = append(h.ClosedPorts, other.ClosedPorts...)
+}
+
+// Counts is the number of probes in each state.
+type Counts struct {
+	Open     int `json:"open"`
+	Closed   int `json:"closed"`
+	Filtered int `json:"filtered"`
+}
+
+func (h *HostSummary) counts() Counts {
+	return Counts{Open: len(h.Open), Closed: h.Closed, Filtered: len(h.Filtered)}
+}
+
+// Report collects host summaries and renders them in one of the supported
+// output formats. With listClosed set, closed ports are kept individually
+// and appear in the json and csv output.
+type Report struct {
+	listClosed bool
+	hosts      map[string]*HostSummary
+}
+
+func newReport(listClosed bool) *Report {
+	return &Report{listClosed: listClosed, hosts: make(map[string]*HostSummary)}
+}
+
+func (r *Report) host(ip string) *HostSummary {
+	h, ok := r.hosts[ip]
+	if !ok {
+		h = &HostSummary{IP: ip}
+		r.hosts[ip] = h
+	}
+	return h
+}
+
+// Add records a single probe result.
+func (r *Report) Add(result ScanResult) {
+	r.host(result.IP).add(result, r.listClosed)
+}
+
+// Merge folds in a summary produced earlier, e.g. restored from a checkpoint.
+func (r *Report) Merge(summary HostSummary) {
+	r.host(summary.IP).merge(summary)
+}
+
+// Hosts returns every host summary sorted by address, with sorted ports.
+func (r *Report) Hosts() []HostSummary {
+	hosts := make([]HostSummary, 0, len(r.hosts))
+	for _, h := range r.hosts {
+		sort.Ints(h.Open)
+		sort.Ints(h.Filtered)
+		sort.Ints(h.ClosedPorts)
+		hosts = append(hosts, *h)
+	}
+	sort.Slice(hosts, func(i, j int) bool {
+		return compareIP(hosts[i].IP, hosts[j].IP) < 0
+	})
+	return hosts
+}
+
+// Totals sums the counts of every host.
+func (r *Report) Totals() Counts {
+	var total Counts
+	for _, h := range r.hosts {
+		c := h.counts()
+		total.Open += c.Open
+		total.Closed += c.Closed
+		total.Filtered += c.Filtered
+	}
+	return total
+}
+
+// Write renders the report as "table", "json" or "csv".
+func (r *Report) Write(w io.Writer, format string) error {
+	switch format {
+	case "table":
+		return r.writeTable(w)
+	case "json":
+		return r.writeJSON(w)
+	case "csv":
+		return r.writeCSV(w)
+	}
+	return fmt.Errorf("unknown output format %q", format)
+}
+
+func (r *Report) writeTable(w io.Writer) error {
+	hosts := r.Hosts()
+
+	tbl := table.New("IP Address", "Open Ports", "Open", "Closed", "Filtered").WithWriter(w)
+	for _, h := range hosts {
+		c := h.counts()
+		tbl.AddRow(h.IP, joinPorts(h.Open), c.Open, c.Closed, c.Filtered)
+	}
+	tbl.Print()
+
+	total := r.Totals()
+	_, err := fmt.Fprintf(w, "\n%d host(s) scanned: %d open, %d closed, %d filtered\n",
+		len(hosts), total.Open, total.Closed, total.Filtered)
+	return err
+}
+
+type jsonHost struct {
+	HostSummary
+	Counts Counts `json:"counts"`
+}
+
+type jsonReport struct {
+	Summary Counts     `json:"summary"`
+	Hosts   []jsonHost `json:"hosts"`
+}
+
+func (r *Report) writeJSON(w io.Writer) error {
+	doc := jsonReport{Summary: r.Totals(), Hosts: []jsonHost{}}
+	for _, h := range r.Hosts() {
+		if h.Open == nil {
+			h.Open = []int{}
+		}
+		if h.Filtered == nil {
+			h.Filtered = []int{}
+		}
+		doc.Hosts = append(doc.Hosts, jsonHost{HostSummary: h, Counts: h.counts()})
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(doc)
+}
+
+// writeCSV emits one row per port. Closed ports are only written when the
+// report lists them; otherwise they appear only in the totals.
+func (r *Report) writeCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"ip", "port", "state"})
+	for _, h := range r.Hosts() {
+		for _, port := range h.Open {
+			cw.Write([]string{h.IP, strconv.Itoa(port), string(StateOpen)})
+		}
+		for _, port := range h.Filtered {
+			cw.Write([]string{h.IP, strconv.Itoa(port), string(StateFiltered)})
+		}
+		for _, port := range h.ClosedPorts {
+			cw.Write([]string{h.IP, strconv.Itoa(port), string(StateClosed)})
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func joinPorts(ports []int) string {
+	return strings.Trim(strings.Replace(fmt.Sprint(ports), " ", ", ", -1), "[]")
+}
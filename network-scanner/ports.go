@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// topPorts lists the most commonly open TCP ports, most frequent first, as
+// ranked by nmap's nmap-services frequency data.
+var topPorts = []int{
+	80, 23, 443, 21, 22, 25, 3389, 110, 445, 139,
+	143, 53, 135, 3306, 8080, 1723, 111, 995, 993, 5900,
+	1025, 587, 8888, 199, 1720, 465, 548, 113, 81, 6001,
+	10000, 514, 5060, 179, 1026, 2000, 8443, 8000, 32768, 554,
+	26, 1433, 49152, 2001, 515, 8008, 49154, 1027, 5666, 646,
+	5000, 5631, 631, 49153, 8081, 2049, 88, 79, 5800, 106,
+	2121, 1110, 49155, 6000, 513, 990, 5357, 427, 49156, 543,
+	544, 5101, 144, 7, 389, 8009, 3128, 444, 9999, 5009,
+	7070, 5190, 3000, 5432, 1900, 3986, 13, 1029, 9, 5051,
+	6646, 49157, 1028, 873, 1755, 2717, 4899, 9100, 119, 37,
+}
+
+// parsePorts expands a port specification such as "22,80,8000-8100" into a
+// sorted list of unique ports.
+func parsePorts(spec string) ([]int, error) {
+	seen := make(map[int]bool)
+	var ports []int
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		first, last := part, part
+		if lo, hi, ok := strings.Cut(part, "-"); ok {
+			first, last = lo, hi
+		}
+		start, err := parsePort(first)
+		if err != nil {
+			return nil, err
+		}
+		end, err := parsePort(last)
+		if err != nil {
+			return nil, err
+		}
+		if end < start {
+			return nil, fmt.Errorf("invalid port range %q", part)
+		}
+
+		for port := start; port <= end; port++ {
+			if !seen[port] {
+				seen[port] = true
+				ports = append(ports, port)
+			}
+		}
+	}
+	if len(ports) == 0 {
+		return nil, fmt.Errorf("no ports in %q", spec)
+	}
+	sort.Ints(ports)
+	return ports, nil
+}
+
+func parsePort(s string) (int, error) {
+	port, err := strconv.Atoi(s)
+	if err != nil || port < 1 || port > 65535 {
+		return 0, fmt.Errorf("invalid port %q", s)
+	}
+	return port, nil
+}
+
+// mostCommonPorts returns the n most frequently open ports in ascending order.
+func mostCommonPorts(n int) ([]int, error) {
+	if n < 1 || n > len(topPorts) {
+		return nil, fmt.Errorf("top ports must be between 1 and %d", len(topPorts))
+	}
+	ports := append([]int(nil), topPorts[:n]...)
+	sort.Ints(ports)
+	return ports, nil
+}
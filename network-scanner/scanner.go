@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// Probe is a single (IP, port) pair waiting to be scanned.
+type Probe struct {
+	IP   string
+	Port int
+	host int
+}
+
+// Scanner runs probes through a fixed-size pool of workers, optionally
+// throttled to a maximum number of probes per second.
+type Scanner struct {
+	Concurrency int
+	Rate        int
+	Timeout     time.Duration
+}
+
+// Run consumes probes until the channel is closed and streams results back.
+// The returned channel is closed once every probe has been answered.
+func (s *Scanner) Run(probes <-chan Probe) <-chan ScanResult {
+	results := make(chan ScanResult, s.Concurrency)
+
+	var tick <-chan time.Time
+	var ticker *time.Ticker
+	if s.Rate > 0 {
+		ticker = time.NewTicker(time.Second / time.Duration(s.Rate))
+		tick = ticker.C
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < s.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for probe := range probes {
+				if tick != nil {
+					<-tick
+				}
+				results <- s.probe(probe)
+			}
+		}()
+	}
+
+	go func() {
+		wg.Wait()
+		if ticker != nil {
+			ticker.Stop()
+		}
+		close(results)
+	}()
+
+	return results
+}
+
+func (s *Scanner) probe(p Probe) ScanResult {
+	result := ScanResult{IP: p.IP, Port: p.Port, host: p.host}
+	conn, err := net.DialTimeout(protocol, net.JoinHostPort(p.IP, fmt.Sprint(p.Port)), s.Timeout)
+	result.State = classify(err)
+	result.Err = err
+	if err == nil {
+		conn.Close()
+	}
+	return result
+}
+
+// generateProbes feeds every target/port combination into an unbuffered
+// channel, so only the probes currently being worked on are held in memory.
+// Hosts for which skip returns true are left out, and generation stops as
+// soon as ctx is cancelled.
+func generateProbes(ctx context.Context, targets *TargetList, ports []int, skip func(index int) bool) <-chan Probe {
+	probes := make(chan Probe)
+	go func() {
+		defer close(probes)
+		targets.Walk(func(index int, ip string) bool {
+			if skip(index) {
+				return true
+			}
+			for _, port := range ports {
+				select {
+				case probes <- Probe{IP: ip, Port: port, host: index}:
+				case <-ctx.Done():
+					return false
+				}
+			}
+			return true
+		})
+	}()
+	return probes
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"math/big"
+	"net"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// addrRange is an inclusive range of addresses of a single family.
+type addrRange struct {
+	first netip.Addr
+	last  netip.Addr
+}
+
+func (r addrRange) contains(addr netip.Addr) bool {
+	return r.first.Compare(addr) <= 0 && addr.Compare(r.last) <= 0
+}
+
+// String formats the range as a target specification that parses back to
+// the same range.
+func (r addrRange) String() string {
+	if r.first == r.last {
+		return r.first.String()
+	}
+	return r.first.String() + "-" + r.last.String()
+}
+
+// size returns the number of addresses in the range.
+func (r addrRange) size() *big.Int {
+	first, last := r.first.As16(), r.last.As16()
+	n := new(big.Int).Sub(new(big.Int).SetBytes(last[:]), new(big.Int).SetBytes(first[:]))
+	return n.Add(n, big.NewInt(1))
+}
+
+// Largest target AddSpec accepts without AllowLarge: an IPv4 /8 or an IPv6
+// /112. Anything bigger is almost always a typo, and would take days to walk.
+const (
+	maxRange4 = 1 << 24
+	maxRange6 = 1 << 16
+)
+
+func rangeSpecs(ranges []addrRange) []string {
+	specs := make([]string, len(ranges))
+	for i, r := range ranges {
+		specs[i] = r.String()
+	}
+	return specs
+}
+
+// TargetList is the expanded set of hosts to scan. Ranges are walked lazily
+// so that large networks never have to be held in memory.
+type TargetList struct {
+	// AllowLarge lifts the limit on how many addresses one specification
+	// may cover.
+	AllowLarge bool
+
+	specs    []string
+	excludes []string
+	ranges   []addrRange
+	exclude  []addrRange
+}
+
+// AddSpec parses a target specification and appends it to the list.
+// Accepted forms are a single address, a CIDR prefix (IPv4 or IPv6), a range
+// such as 10.0.0.1-10.0.0.50 or 10.0.0.1-50, and a hostname.
+func (t *TargetList) AddSpec(spec string) error {
+	ranges, err := parseTargetSpec(spec)
+	if err != nil {
+		return err
+	}
+	if !t.AllowLarge {
+		for _, r := range ranges {
+			limit := int64(maxRange6)
+			if r.first.Is4() {
+				limit = maxRange4
+			}
+			if size := r.size(); size.Cmp(big.NewInt(limit)) > 0 {
+				return fmt.Errorf("target %q covers %s addresses, more than the limit of %d (use -allow-large to scan it)", spec, size, limit)
+			}
+		}
+	}
+	t.specs = append(t.specs, rangeSpecs(ranges)...)
+	t.ranges = append(t.ranges, ranges...)
+	return nil
+}
+
+// Exclude removes every address matched by spec from the walk.
+func (t *TargetList) Exclude(spec string) error {
+	ranges, err := parseTargetSpec(spec)
+	if err != nil {
+		return err
+	}
+	t.excludes = append(t.excludes, rangeSpecs(ranges)...)
+	t.exclude = append(t.exclude, ranges...)
+	return nil
+}
+
+// AddFile reads target specifications from a file, one or more per line.
+// Blank lines and anything after a '#' are ignored.
+func (t *TargetList) AddFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		for _, spec := range strings.Fields(line) {
+			if err := t.AddSpec(spec); err != nil {
+				return fmt.Errorf("%s: %w", path, err)
+			}
+		}
+	}
+	return scanner.Err()
+}
+
+// Empty reports whether no targets have been added.
+func (t *TargetList) Empty() bool {
+	return len(t.ranges) == 0
+}
+
+// Specs returns the targets as address ranges in the order they were added,
+// with hostnames replaced by the addresses they resolved to. Rebuilding a
+// list from them never touches DNS, so the walk comes out the same even if
+// a name has since moved.
+func (t *TargetList) Specs() []string {
+	return t.specs
+}
+
+// Excludes returns the exclusions as address ranges, like Specs.
+func (t *TargetList) Excludes() []string {
+	return t.excludes
+}
+
+// Walk calls fn with the position and address of every target that is not
+// excluded, in the order the specifications were added. The walk is
+// deterministic for a given list, and stops early if fn returns false.
+func (t *TargetList) Walk(fn func(index int, ip string) bool) {
+	index := 0
+	for _, r := range t.ranges {
+		for addr := r.first; addr.IsValid() && addr.Compare(r.last) <= 0; addr = addr.Next() {
+			if t.excluded(addr) {
+				continue
+			}
+			if !fn(index, addr.String()) {
+				return
+			}
+			index++
+		}
+	}
+}
+
+func (t *TargetList) excluded(addr netip.Addr) bool {
+	for _, r := range t.exclude {
+		if r.contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+func parseTargetSpec(spec string) ([]addrRange, error) {
+	spec = strings.TrimSpace(spec)
+	if spec == "" {
+		return nil, fmt.Errorf("empty target")
+	}
+
+	if strings.Contains(spec, "/") {
+		prefix, err := netip.ParsePrefix(spec)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q: %w", spec, err)
+		}
+		return []addrRange{prefixRange(prefix)}, nil
+	}
+
+	if addr, err := netip.ParseAddr(spec); err == nil {
+		return []addrRange{{first: addr, last: addr}}, nil
+	}
+
+	if first, last, ok := strings.Cut(spec, "-"); ok {
+		if r, err := parseRange(first, last); err == nil {
+			return []addrRange{r}, nil
+		} else if net.ParseIP(first) != nil {
+			return nil, fmt.Errorf("invalid range %q: %w", spec, err)
+		}
+	}
+
+	addrs, err := net.LookupIP(spec)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve %q: %w", spec, err)
+	}
+	var ranges []addrRange
+	for _, ip := range addrs {
+		addr, ok := netip.AddrFromSlice(ip)
+		if !ok {
+			continue
+		}
+		addr = addr.Unmap()
+		ranges = append(ranges, addrRange{first: addr, last: addr})
+	}
+	return ranges, nil
+}
+
+// parseRange accepts either two full addresses or, for IPv4, a full start
+// address and the last octet of the end address.
+func parseRange(first, last string) (addrRange, error) {
+	start, err := netip.ParseAddr(first)
+	if err != nil {
+		return addrRange{}, err
+	}
+
+	end, err := netip.ParseAddr(last)
+	if err != nil {
+		if !start.Is4() {
+			return addrRange{}, err
+		}
+		octet, convErr := strconv.ParseUint(last, 10, 8)
+		if convErr != nil {
+			return addrRange{}, fmt.Errorf("bad range end %q", last)
+		}
+		b := start.As4()
+		b[3] = byte(octet)
+		end = netip.AddrFrom4(b)
+	}
+
+	if start.Is4() != end.Is4() {
+		return addrRange{}, fmt.Errorf("mixed address families")
+	}
+	if end.Less(start) {
+		return addrRange{}, fmt.Errorf("range end is before start")
+	}
+	return addrRange{first: start, last: end}, nil
+}
+
+// prefixRange returns the usable host addresses of a prefix. For IPv4
+// networks larger than a /31 the network and broadcast addresses are skipped;
+// /31 and /32 networks (and all IPv6 prefixes) are used as-is.
+func prefixRange(prefix netip.Prefix) addrRange {
+	prefix = prefix.Masked()
+	first := prefix.Addr()
+	last := lastAddr(prefix)
+	if first.Is4() && prefix.Bits() < 31 {
+		first = first.Next()
+		last = last.Prev()
+	}
+	return addrRange{first: first, last: last}
+}
+
+func lastAddr(prefix netip.Prefix) netip.Addr {
+	if prefix.Addr().Is4() {
+		b := prefix.Addr().As4()
+		for i := prefix.Bits(); i < 32; i++ {
+			b[i/8] |= 1 << (7 - i%8)
+		}
+		return netip.AddrFrom4(b)
+	}
+	b := prefix.Addr().As16()
+	for i := prefix.Bits(); i < 128; i++ {
+		b[i/8] |= 1 << (7 - i%8)
+	}
+	return netip.AddrFrom16(b)
+}
@@ -1,8 +1,11 @@
 package main
 
 import (
+	"flag"
 	"log"
+	"os"
 	"os/exec"
+	"strings"
 	"time"
 
 	"github.com/bytemare/gonetmon"
@@ -10,6 +13,10 @@ import (
 )
 
 func main() {
+	xmlOut := flag.String("xml", "", "Write an nmap-compatible XML report to this file")
+	jsonOut := flag.String("json", "", "Write a JSON report to this file")
+	flag.Parse()
+
 	logger, err := zap.NewProduction()
 	if err != nil {
 		log.Fatalf("Failed to initialize logger: %v", err)
@@ -24,10 +31,15 @@ func main() {
 		logger.Fatal("Failed to initialize scanner", zap.Error(err))
 	}
 
-	results, err := scanner.Scan(5 * time.Second)
+	timeout := 5 * time.Second
+	start := time.Now()
+	results, err := scanner.Scan(timeout)
 	if err != nil {
 		logger.Fatal("Failed to scan ports", zap.Error(err))
 	}
+	end := time.Now()
+
+	host := HostReport{Address: targetIP, Start: start, End: end}
 
 	for _, result := range results {
 		switch result.State {
@@ -39,12 +51,52 @@ func main() {
 		case portscanner.Filtered:
 			logger.Info("Port is filtered", zap.Int("port", result.Port))
 		}
+		host.Ports = append(host.Ports, PortReport{
+			Port:     result.Port,
+			Protocol: "tcp",
+			State:    stateName(result.State),
+			Service:  result.Service,
+		})
+	}
+
+	report := &ScanReport{
+		Args:    strings.Join(os.Args, " "),
+		Start:   start,
+		End:     end,
+		Timeout: timeout,
+		Hosts:   []HostReport{host},
+	}
+	if *xmlOut != "" {
+		if err := report.WriteXML(*xmlOut); err != nil {
+			logger.Error("Failed to write XML report", zap.Error(err))
+		} else {
+			logger.Info("XML report written", zap.String("path", *xmlOut))
+		}
+	}
+	if *jsonOut != "" {
+		if err := report.WriteJSON(*jsonOut); err != nil {
+			logger.Error("Failed to write JSON report", zap.Error(err))
+		} else {
+			logger.Info("JSON report written", zap.String("path", *jsonOut))
+		}
 	}
 
 	monitorNetworkTraffic(logger)
 	triggerAlertsAndLogEvents(logger)
 }
 
+func stateName(state portscanner.State) string {
+	switch state {
+	case portscanner.Open:
+		return "open"
+	case portscanner.Closed:
+		return "closed"
+	case portscanner.Filtered:
+		return "filtered"
+	}
+	return "unknown"
+}
+
 func checkVulnerabilities(port int, service string, logger *zap.Logger) {
 	logger.Info("Checking vulnerabilities", zap.String("service", service), zap.Int("port", port))
 	cmd := exec.Command("govulncheck", "./...")
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The scanner name and version recorded in JSON reports and in the XML
+// version attribute. The XML scanner attribute and run summary say "nmap"
+// instead, because ndiff and most nmap XML libraries refuse documents from
+// any other scanner.
+const (
+	scannerName    = "portscanner"
+	scannerVersion = "1.0"
+)
+
+// ScanReport holds everything needed to render a scan in nmap XML or JSON form.
+type ScanReport struct {
+	Args    string
+	Start   time.Time
+	End     time.Time
+	Timeout time.Duration
+	Hosts   []HostReport
+}
+
+type HostReport struct {
+	Address string
+	Start   time.Time
+	End     time.Time
+	Ports   []PortReport
+}
+
+type PortReport struct {
+	Port     int
+	Protocol string
+	State    string
+	Service  string
+}
+
+// stateReason gives nmap's reason for a port state in a connect scan. A
+// completed connect does not reveal which packet came back, so open ports
+// have no reason.
+func stateReason(state string) string {
+	switch state {
+	case "closed":
+		return "conn-refused"
+	case "filtered":
+		return "no-response"
+	}
+	return ""
+}
+
+// status reports a host as up if any probe got an answer, and as unknown if
+// every probe went unanswered, since a filtered host may still be up. The
+// reason is taken from the answers: conn-refused if any port was closed.
+func (h HostReport) status() (state, reason string) {
+	state, reason = "unknown", "no-response"
+	for _, p := range h.Ports {
+		switch p.State {
+		case "closed":
+			return "up", "conn-refused"
+		case "open":
+			state, reason = "up", ""
+		}
+	}
+	return state, reason
+}
+
+// nmap XML layout, following nmap.dtd closely enough for common parsers.
+type nmapRun struct {
+	XMLName          xml.Name     `xml:"nmaprun"`
+	Scanner          string       `xml:"scanner,attr"`
+	Args             string       `xml:"args,attr"`
+	Start            int64        `xml:"start,attr"`
+	StartStr         string       `xml:"startstr,attr"`
+	Version          string       `xml:"version,attr"`
+	XMLOutputVersion string       `xml:"xmloutputversion,attr"`
+	ScanInfo         nmapScanInfo `xml:"scaninfo"`
+	Hosts            []nmapHost   `xml:"host"`
+	RunStats         nmapRunStats `xml:"runstats"`
+}
+
+type nmapScanInfo struct {
+	Type        string `xml:"type,attr"`
+	Protocol    string `xml:"protocol,attr"`
+	NumServices int    `xml:"numservices,attr"`
+	Services    string `xml:"services,attr"`
+}
+
+type nmapHost struct {
+	StartTime int64        `xml:"starttime,attr"`
+	EndTime   int64        `xml:"endtime,attr"`
+	Status    nmapStatus   `xml:"status"`
+	Address   nmapAddress  `xml:"address"`
+	Ports     nmapPortList `xml:"ports"`
+}
+
+type nmapStatus struct {
+	State  string `xml:"state,attr"`
+	Reason string `xml:"reason,attr,omitempty"`
+}
+
+type nmapAddress struct {
+	Addr     string `xml:"addr,attr"`
+	AddrType string `xml:"addrtype,attr"`
+}
+
+type nmapPortList struct {
+	Ports []nmapPort `xml:"port"`
+}
+
+type nmapPort struct {
+	Protocol string      `xml:"protocol,attr"`
+	PortID   int         `xml:"portid,attr"`
+	State    nmapState   `xml:"state"`
+	Service  nmapService `xml:"service"`
+}
+
+type nmapState struct {
+	State  string `xml:"state,attr"`
+	Reason string `xml:"reason,attr,omitempty"`
+}
+
+type nmapService struct {
+	Name   string `xml:"name,attr"`
+	Method string `xml:"method,attr"`
+	Conf   int    `xml:"conf,attr"`
+}
+
+type nmapRunStats struct {
+	Finished nmapFinished `xml:"finished"`
+	Hosts    nmapHostStat `xml:"hosts"`
+}
+
+type nmapFinished struct {
+	Time    int64  `xml:"time,attr"`
+	TimeStr string `xml:"timestr,attr"`
+	Elapsed string `xml:"elapsed,attr"`
+	Summary string `xml:"summary,attr"`
+	Exit    string `xml:"exit,attr"`
+}
+
+type nmapHostStat struct {
+	Up    int `xml:"up,attr"`
+	Down  int `xml:"down,attr"`
+	Total int `xml:"total,attr"`
+}
+
+func addrType(addr string) string {
+	ip := net.ParseIP(addr)
+	if ip != nil && ip.To4() == nil {
+		return "ipv6"
+	}
+	return "ipv4"
+}
+
+func (r *ScanReport) toNmap() nmapRun {
+	run := nmapRun{
+		Scanner:          "nmap",
+		Args:             r.Args,
+		Start:            r.Start.Unix(),
+		StartStr:         r.Start.Format(time.ANSIC),
+		Version:          scannerVersion,
+		XMLOutputVersion: "1.05",
+	}
+
+	seen := make(map[int]bool)
+	var services []string
+	up := 0
+	for _, h := range r.Hosts {
+		state, reason := h.status()
+		host := nmapHost{
+			StartTime: h.Start.Unix(),
+			EndTime:   h.End.Unix(),
+			Status:    nmapStatus{State: state, Reason: reason},
+			Address:   nmapAddress{Addr: h.Address, AddrType: addrType(h.Address)},
+		}
+		if state == "up" {
+			up++
+		}
+		for _, p := range h.Ports {
+			if !seen[p.Port] {
+				seen[p.Port] = true
+				services = append(services, strconv.Itoa(p.Port))
+			}
+			host.Ports.Ports = append(host.Ports.Ports, nmapPort{
+				Protocol: p.Protocol,
+				PortID:   p.Port,
+				State:    nmapState{State: p.State, Reason: stateReason(p.State)},
+				Service:  nmapService{Name: p.Service, Method: "table", Conf: 3},
+			})
+		}
+		run.Hosts = append(run.Hosts, host)
+	}
+
+	run.ScanInfo = nmapScanInfo{
+		Type:        "connect",
+		Protocol:    "tcp",
+		NumServices: len(services),
+		Services:    strings.Join(services, ","),
+	}
+
+	elapsed := r.End.Sub(r.Start).Seconds()
+	run.RunStats = nmapRunStats{
+		Finished: nmapFinished{
+			Time:    r.End.Unix(),
+			TimeStr: r.End.Format(time.ANSIC),
+			Elapsed: fmt.Sprintf("%.2f", elapsed),
+			Summary: fmt.Sprintf("Nmap done at %s; %d IP address (%d host up) scanned in %.2f seconds",
+				r.End.Format(time.ANSIC), len(r.Hosts), up, elapsed),
+			Exit: "success",
+		},
+		Hosts: nmapHostStat{Up: up, Down: len(r.Hosts) - up, Total: len(r.Hosts)},
+	}
+	return run
+}
+
+// WriteXML writes the report as an nmap-compatible XML document.
+func (r *ScanReport) WriteXML(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create XML report: %w", err)
+	}
+
+	if _, err := f.WriteString(xml.Header + "<!DOCTYPE nmaprun>\n"); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write XML report: %w", err)
+	}
+	enc := xml.NewEncoder(f)
+	enc.Indent("", "  ")
+	if err := enc.Encode(r.toNmap()); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to encode XML report: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to write XML report: %w", err)
+	}
+	return nil
+}
+
+type jsonReport struct {
+	Scanner   string     `json:"scanner"`
+	Args      string     `json:"args"`
+	Start     time.Time  `json:"start"`
+	End       time.Time  `json:"end"`
+	Elapsed   float64    `json:"elapsed_seconds"`
+	TimeoutMS int64      `json:"timeout_ms"`
+	Hosts     []jsonHost `json:"hosts"`
+}
+
+type jsonHost struct {
+	Address string     `json:"address"`
+	Status  string     `json:"status"`
+	Reason  string     `json:"reason,omitempty"`
+	Start   time.Time  `json:"start"`
+	End     time.Time  `json:"end"`
+	Ports   []jsonPort `json:"ports"`
+}
+
+type jsonPort struct {
+	Port     int    `json:"port"`
+	Protocol string `json:"protocol"`
+	State    string `json:"state"`
+	Reason   string `json:"reason,omitempty"`
+	Service  string `json:"service,omitempty"`
+}
+
+// WriteJSON writes the report as a single JSON document.
+func (r *ScanReport) WriteJSON(path string) error {
+	doc := jsonReport{
+		Scanner:   scannerName,
+		Args:      r.Args,
+		Start:     r.Start,
+		End:       r.End,
+		Elapsed:   r.End.Sub(r.Start).Seconds(),
+		TimeoutMS: r.Timeout.Milliseconds(),
+	}
+	for _, h := range r.Hosts {
+		state, reason := h.status()
+		host := jsonHost{Address: h.Address, Status: state, Reason: reason, Start: h.Start, End: h.End}
+		for _, p := range h.Ports {
+			host.Ports = append(host.Ports, jsonPort{
+				Port:     p.Port,
+				Protocol: p.Protocol,
+				State:    p.State,
+				Reason:   stateReason(p.State),
+				Service:  p.Service,
+			})
+		}
+		doc.Hosts = append(doc.Hosts, host)
+	}
+
+	data, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode JSON report: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write JSON report: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/mail"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// itemView is the public form of an AuctionItem. Bidder and seller email
+// addresses are only for notifications and are never returned, so bidders
+// cannot harvest each other's addresses; neither are the reserve price and
+// the template variables.
+type itemView struct {
+	ID           int
+	Name         string
+	Seller       string
+	CurrentBid   float64
+	BidHistory   []bidView
+	StartTime    time.Time
+	EndTime      time.Time
+	MinIncrement float64
+	// ReserveMet says whether the current bid would win; the reserve price
+	// itself stays hidden.
+	ReserveMet bool
+	Closed     bool
+	Winner     *bidView
+}
+
+type bidView struct {
+	ItemID int
+	Bidder string
+	Amount float64
+	Placed time.Time
+}
+
+func newBidView(bid Bid) bidView {
+	return bidView{ItemID: bid.ItemID, Bidder: bid.Bidder, Amount: bid.Amount, Placed: bid.Placed}
+}
+
+func newItemView(item AuctionItem) itemView {
+	v := itemView{
+		ID:           item.ID,
+		Name:         item.Name,
+		Seller:       item.Seller,
+		CurrentBid:   item.CurrentBid,
+		BidHistory:   make([]bidView, len(item.BidHistory)),
+		StartTime:    item.StartTime,
+		EndTime:      item.EndTime,
+		MinIncrement: item.MinIncrement,
+		ReserveMet:   item.reserveMet(),
+		Closed:       item.Closed,
+	}
+	for i, bid := range item.BidHistory {
+		v.BidHistory[i] = newBidView(bid)
+	}
+	if item.Winner != nil {
+		winner := newBidView(*item.Winner)
+		v.Winner = &winner
+	}
+	return v
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeAuctionError maps auction errors onto HTTP status codes.
+func writeAuctionError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrInvalidBid), errors.Is(err, ErrInvalidItem):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, ErrItemNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, ErrBidTooLow), errors.Is(err, ErrNotStarted), errors.Is(err, ErrClosed):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// registerAuctionRoutes adds the bidding routes. Creating items, which sets
+// the addresses and template variables mail is sent with, and looking up a
+// bidder's bids need the admin token, and are left out when none is
+// configured.
+func registerAuctionRoutes(r *mux.Router, auction *Auction, token string) {
+	r.HandleFunc("/bid", placeBid(auction)).Methods("POST")
+	r.HandleFunc("/items", listItems(auction)).Methods("GET")
+	r.HandleFunc("/items/{id}", getItem(auction)).Methods("GET")
+
+	if token == "" {
+		log.Printf("No admin token configured, item creation and bidder lookups are disabled")
+		return
+	}
+	admin := bearerAuth(token)
+	r.Handle("/items", admin(createItem(auction))).Methods("POST")
+	r.Handle("/bidders/{email}/bids", admin(bidderBids(auction))).Methods("GET")
+}
+
+func placeBid(auction *Auction) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var bid Bid
+		err := json.NewDecoder(r.Body).Decode(&bid)
+		if err != nil {
+			http.Error(w, "Invalid bid data", http.StatusBadRequest)
+			return
+		}
+
+		item, err := auction.PlaceBid(bid)
+		if err != nil {
+			writeAuctionError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, newItemView(item))
+	}
+}
+
+func listItems(auction *Auction) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		items, err := auction.Items()
+		if err != nil {
+			writeAuctionError(w, err)
+			return
+		}
+		views := make([]itemView, len(items))
+		for i, item := range items {
+			views[i] = newItemView(item)
+		}
+		writeJSON(w, http.StatusOK, views)
+	}
+}
+
+func createItem(auction *Auction) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var n NewItem
+		dec := json.NewDecoder(r.Body)
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&n); err != nil {
+			http.Error(w, "Invalid item data", http.StatusBadRequest)
+			return
+		}
+		item, err := auction.CreateItem(n)
+		if err != nil {
+			writeAuctionError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusCreated, item)
+	}
+}
+
+func getItem(auction *Auction) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			http.Error(w, "Invalid item ID", http.StatusBadRequest)
+			return
+		}
+		item, err := auction.Item(id)
+		if err != nil {
+			writeAuctionError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, newItemView(item))
+	}
+}
+
+func bidderBids(auction *Auction) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		email := mux.Vars(r)["email"]
+		if _, err := mail.ParseAddress(email); err != nil {
+			http.Error(w, "Invalid email address", http.StatusBadRequest)
+			return
+		}
+		bids, err := auction.ActiveBids(email)
+		if err != nil {
+			writeAuctionError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, bids)
+	}
+}
+
+// registerMailRoutes exposes the dead-letter list so failed notifications
+// can be inspected, replayed or discarded. Dead mail holds recipients and
+// message bodies, so the routes need the admin token and are left out
+// entirely when none is configured.
+func registerMailRoutes(r *mux.Router, queue *MailQueue, token string) {
+	if token == "" {
+		log.Printf("No admin token configured, dead-letter routes are disabled")
+		return
+	}
+	admin := r.PathPrefix("/mail").Subrouter()
+	admin.Use(bearerAuth(token))
+	admin.HandleFunc("/dead", listDeadMail(queue)).Methods("GET")
+	admin.HandleFunc("/dead/{id}/replay", replayDeadMail(queue)).Methods("POST")
+	admin.HandleFunc("/dead/{id}", discardDeadMail(queue)).Methods("DELETE")
+}
+
+func bearerAuth(token string) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func mailID(w http.ResponseWriter, r *http.Request) (uint64, bool) {
+	id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid message ID", http.StatusBadRequest)
+		return 0, false
+	}
+	return id, true
+}
+
+func writeMailError(w http.ResponseWriter, err error) {
+	if errors.Is(err, ErrMailNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+}
+
+func listDeadMail(queue *MailQueue) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		dead, err := queue.Dead()
+		if err != nil {
+			writeMailError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, dead)
+	}
+}
+
+func replayDeadMail(queue *MailQueue) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := mailID(w, r)
+		if !ok {
+			return
+		}
+		mail, err := queue.Replay(id)
+		if err != nil {
+			writeMailError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusAccepted, mail)
+	}
+}
+
+func discardDeadMail(queue *MailQueue) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := mailID(w, r)
+		if !ok {
+			return
+		}
+		if err := queue.Discard(id); err != nil {
+			writeMailError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,337 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/mail"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrItemNotFound = errors.New("item not found")
+	ErrInvalidBid   = errors.New("invalid bid")
+	ErrBidTooLow    = errors.New("bid too low")
+	ErrNotStarted   = errors.New("auction has not started")
+	ErrClosed       = errors.New("auction has closed")
+	ErrInvalidItem  = errors.New("invalid item")
+)
+
+// Auction serializes every change to the items in its repository. Bids are
+// validated and committed under the lock; notifications are queued only
+// once the lock is released and the bid is final.
+type Auction struct {
+	mu     sync.Mutex
+	repo   ItemRepository
+	mailer *Mailer
+	config AuctionConfig
+}
+
+func NewAuction(repo ItemRepository, mailer *Mailer, config AuctionConfig) *Auction {
+	return &Auction{repo: repo, mailer: mailer, config: config}
+}
+
+func (item *AuctionItem) ended(now time.Time) bool {
+	return !item.EndTime.IsZero() && !now.Before(item.EndTime)
+}
+
+// reserveMet reports whether the leading bid, if any, meets the reserve.
+func (item *AuctionItem) reserveMet() bool {
+	n := len(item.BidHistory)
+	return n > 0 && item.BidHistory[n-1].Amount >= item.ReservePrice
+}
+
+// checkAmount enforces the minimum increment. The first bid only has to beat
+// the opening price; later ones must beat the current bid by MinIncrement.
+func (item *AuctionItem) checkAmount(amount float64) error {
+	if len(item.BidHistory) == 0 || item.MinIncrement <= 0 {
+		if amount <= item.CurrentBid {
+			return fmt.Errorf("%w: must be more than %.2f", ErrBidTooLow, item.CurrentBid)
+		}
+		return nil
+	}
+	if minimum := item.CurrentBid + item.MinIncrement; amount < minimum {
+		return fmt.Errorf("%w: must be at least %.2f", ErrBidTooLow, minimum)
+	}
+	return nil
+}
+
+// snapshot deep-copies item.
+func (item *AuctionItem) snapshot() AuctionItem {
+	c := *item
+	c.BidHistory = append([]Bid(nil), item.BidHistory...)
+	if item.Winner != nil {
+		winner := *item.Winner
+		c.Winner = &winner
+	}
+	return c
+}
+
+func validateBid(bid Bid) error {
+	if bid.Bidder == "" {
+		return fmt.Errorf("%w: bidder is required", ErrInvalidBid)
+	}
+	if _, err := mail.ParseAddress(bid.Email); err != nil {
+		return fmt.Errorf("%w: invalid email address %q", ErrInvalidBid, bid.Email)
+	}
+	if bid.Amount <= 0 || math.IsInf(bid.Amount, 0) || math.IsNaN(bid.Amount) {
+		return fmt.Errorf("%w: amount must be a positive number", ErrInvalidBid)
+	}
+	return nil
+}
+
+// PlaceBid commits bid if it beats the current bid and returns the item as
+// it stands after the bid.
+func (a *Auction) PlaceBid(bid Bid) (AuctionItem, error) {
+	if err := validateBid(bid); err != nil {
+		return AuctionItem{}, err
+	}
+	bid.Placed = time.Now()
+
+	item, previousBid, err := a.commitBid(bid)
+	if err != nil {
+		return AuctionItem{}, err
+	}
+
+	// Raising your own bid doesn't need an outbid notice.
+	if previousBid != nil && previousBid.Email != bid.Email {
+		err := a.mailer.Notify("outbid", previousBid.Email, TemplateData{
+			Item:        &item,
+			Bid:         bid,
+			PreviousBid: *previousBid,
+		})
+		if err != nil {
+			log.Printf("Error sending email to %s: %v", previousBid.Email, err)
+		}
+	}
+	return item, nil
+}
+
+// commitBid applies bid under the lock and returns the updated item along
+// with the bid it displaced, if any.
+func (a *Auction) commitBid(bid Bid) (AuctionItem, *Bid, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	item, err := a.repo.Get(bid.ItemID)
+	if err != nil {
+		return AuctionItem{}, nil, err
+	}
+	now := bid.Placed
+	if now.Before(item.StartTime) {
+		return AuctionItem{}, nil, fmt.Errorf("%w: bidding opens at %s", ErrNotStarted, item.StartTime.Format(time.RFC3339))
+	}
+	// The closer may not have run yet, so check the end time as well.
+	if item.Closed || item.ended(now) {
+		return AuctionItem{}, nil, fmt.Errorf("%w: %d", ErrClosed, bid.ItemID)
+	}
+	if err := item.checkAmount(bid.Amount); err != nil {
+		return AuctionItem{}, nil, err
+	}
+
+	var previousBid *Bid
+	if n := len(item.BidHistory); n > 0 {
+		previous := item.BidHistory[n-1]
+		previousBid = &previous
+	}
+	item.CurrentBid = bid.Amount
+	item.BidHistory = append(item.BidHistory, bid)
+	if w := a.config.SoftCloseWindow; w > 0 && !item.EndTime.IsZero() && item.EndTime.Sub(now) < w {
+		// Only ever push the end back; an extension shorter than the time
+		// left must not close the auction early.
+		if end := now.Add(a.config.SoftCloseExtension); end.After(item.EndTime) {
+			item.EndTime = end
+			log.Printf("Bid on item %d in the final %s, extending auction to %s",
+				item.ID, w, item.EndTime.Format(time.RFC3339))
+		}
+	}
+	if err := a.repo.RecordBid(item, bid); err != nil {
+		return AuctionItem{}, nil, err
+	}
+	return item, previousBid, nil
+}
+
+// NewItem is the request body for creating an item. StartTime defaults to
+// now.
+type NewItem struct {
+	Name         string
+	Seller       string
+	SellerEmail  string
+	StartingBid  float64
+	StartTime    time.Time
+	EndTime      time.Time
+	MinIncrement float64
+	ReservePrice float64
+	Vars         map[string]string
+}
+
+func validAmount(v float64) bool {
+	return v >= 0 && !math.IsInf(v, 0) && !math.IsNaN(v)
+}
+
+func (n NewItem) validate() error {
+	if strings.TrimSpace(n.Name) == "" {
+		return fmt.Errorf("%w: name is required", ErrInvalidItem)
+	}
+	if n.SellerEmail != "" {
+		if _, err := mail.ParseAddress(n.SellerEmail); err != nil {
+			return fmt.Errorf("%w: invalid seller email address %q", ErrInvalidItem, n.SellerEmail)
+		}
+	}
+	if !validAmount(n.StartingBid) || !validAmount(n.MinIncrement) || !validAmount(n.ReservePrice) {
+		return fmt.Errorf("%w: amounts must not be negative", ErrInvalidItem)
+	}
+	if n.EndTime.IsZero() {
+		return fmt.Errorf("%w: end time is required", ErrInvalidItem)
+	}
+	if !n.EndTime.After(n.StartTime) {
+		return fmt.Errorf("%w: end time must be after start time", ErrInvalidItem)
+	}
+	if !n.EndTime.After(time.Now()) {
+		return fmt.Errorf("%w: end time is in the past", ErrInvalidItem)
+	}
+	return nil
+}
+
+// CreateItem validates n and adds it to the repository.
+func (a *Auction) CreateItem(n NewItem) (AuctionItem, error) {
+	if n.StartTime.IsZero() {
+		n.StartTime = time.Now()
+	}
+	if err := n.validate(); err != nil {
+		return AuctionItem{}, err
+	}
+	return a.repo.Create(AuctionItem{
+		Name:         strings.TrimSpace(n.Name),
+		Seller:       n.Seller,
+		SellerEmail:  n.SellerEmail,
+		CurrentBid:   n.StartingBid,
+		StartTime:    n.StartTime,
+		EndTime:      n.EndTime,
+		MinIncrement: n.MinIncrement,
+		ReservePrice: n.ReservePrice,
+		Vars:         n.Vars,
+	})
+}
+
+func (a *Auction) Items() ([]AuctionItem, error) {
+	return a.repo.List()
+}
+
+func (a *Auction) Item(id int) (AuctionItem, error) {
+	return a.repo.Get(id)
+}
+
+// BidderBid summarizes a bidder's standing on one open item.
+type BidderBid struct {
+	ItemID     int
+	Name       string
+	Amount     float64
+	CurrentBid float64
+	Leading    bool
+	EndTime    time.Time
+}
+
+// ActiveBids returns the open items email has bid on, with their highest
+// bid on each.
+func (a *Auction) ActiveBids(email string) ([]BidderBid, error) {
+	items, err := a.repo.List()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	active := []BidderBid{}
+	for _, item := range items {
+		if item.Closed || item.ended(now) {
+			continue
+		}
+		var best *Bid
+		for i, bid := range item.BidHistory {
+			if strings.EqualFold(bid.Email, email) && (best == nil || bid.Amount > best.Amount) {
+				best = &item.BidHistory[i]
+			}
+		}
+		if best == nil {
+			continue
+		}
+		last := item.BidHistory[len(item.BidHistory)-1]
+		active = append(active, BidderBid{
+			ItemID:     item.ID,
+			Name:       item.Name,
+			Amount:     best.Amount,
+			CurrentBid: item.CurrentBid,
+			Leading:    strings.EqualFold(last.Email, email),
+			EndTime:    item.EndTime,
+		})
+	}
+	return active, nil
+}
+
+// RunCloser closes auctions as they reach their end time until ctx is
+// cancelled.
+func (a *Auction) RunCloser(ctx context.Context) {
+	ticker := time.NewTicker(a.config.CloseInterval)
+	defer ticker.Stop()
+	for {
+		a.closeEnded(time.Now())
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// closeEnded closes every open auction whose end time has passed. The
+// highest bid wins if it meets the reserve price.
+func (a *Auction) closeEnded(now time.Time) {
+	var closed []AuctionItem
+	a.mu.Lock()
+	items, err := a.repo.List()
+	if err != nil {
+		a.mu.Unlock()
+		log.Printf("Error loading items to close: %v", err)
+		return
+	}
+	for _, item := range items {
+		if item.Closed || !item.ended(now) {
+			continue
+		}
+		item.Closed = true
+		if item.reserveMet() {
+			winner := item.BidHistory[len(item.BidHistory)-1]
+			item.Winner = &winner
+		}
+		if err := a.repo.Update(item); err != nil {
+			log.Printf("Error closing auction for item %d: %v", item.ID, err)
+			continue
+		}
+		closed = append(closed, item)
+	}
+	a.mu.Unlock()
+
+	for i := range closed {
+		a.notifyClosed(&closed[i])
+	}
+}
+
+func (a *Auction) notifyClosed(item *AuctionItem) {
+	data := TemplateData{Item: item, Winner: item.Winner}
+	if item.Winner != nil {
+		log.Printf("Auction for item %d closed, won by %s at %.2f", item.ID, item.Winner.Bidder, item.Winner.Amount)
+		if err := a.mailer.Notify("won", item.Winner.Email, data); err != nil {
+			log.Printf("Error sending email to %s: %v", item.Winner.Email, err)
+		}
+	} else {
+		log.Printf("Auction for item %d closed without a winner", item.ID)
+	}
+	if item.SellerEmail != "" {
+		if err := a.mailer.Notify("closed", item.SellerEmail, data); err != nil {
+			log.Printf("Error sending email to %s: %v", item.SellerEmail, err)
+		}
+	}
+}
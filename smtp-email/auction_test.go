@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/textproto"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testAuction struct {
+	*Auction
+	server *TestSMTPServer
+	queue  *MailQueue
+}
+
+// newTestAuction wires an auction to a queue and a Sender that delivers
+// over SMTP to an in-process server. Everything is torn down when the test
+// ends.
+func newTestAuction(t *testing.T) *testAuction {
+	t.Helper()
+	server, err := StartTestSMTPServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { server.Close() })
+
+	queue, err := OpenMailQueue(filepath.Join(t.TempDir(), "queue.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { queue.Close() })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	transport := &SMTPTransport{config: server.Config("auctions@example.com")}
+	sender := NewSender(queue, transport, QueueConfig{MaxAttempts: 5, Backoff: time.Second, MaxBackoff: time.Second})
+	done := make(chan struct{})
+	go func() {
+		sender.Run(ctx)
+		close(done)
+	}()
+	// Cleanups run last in, first out, so the sender stops before the
+	// queue is closed under it.
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	auction := NewAuction(NewMemoryRepository(), NewMailer(NewTemplates("templates", nil), queue), AuctionConfig{})
+	return &testAuction{Auction: auction, server: server, queue: queue}
+}
+
+func (a *testAuction) createItem(t *testing.T, n NewItem) AuctionItem {
+	t.Helper()
+	if n.EndTime.IsZero() {
+		n.EndTime = time.Now().Add(time.Hour)
+	}
+	item, err := a.CreateItem(n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return item
+}
+
+func (a *testAuction) bid(t *testing.T, item AuctionItem, bidder, email string, amount float64) {
+	t.Helper()
+	if _, err := a.PlaceBid(Bid{ItemID: item.ID, Bidder: bidder, Email: email, Amount: amount}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestOutbidNotification places two bids and checks that the first bidder
+// is sent an outbid notice, with text and HTML parts, through the queue,
+// the Sender and the SMTP transport.
+func TestOutbidNotification(t *testing.T) {
+	a := newTestAuction(t)
+	item := a.createItem(t, NewItem{Name: "Vintage Watch", StartingBid: 100})
+	a.bid(t, item, "Alice", "alice@example.com", 110)
+	a.bid(t, item, "Bob", "bob@example.com", 125)
+
+	msgs, err := a.server.WaitForMessages(1, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 1 {
+		t.Fatalf("got %d messages, want 1", len(msgs))
+	}
+	subject, parts := readMessage(t, msgs[0], "alice@example.com")
+	if !strings.Contains(subject, "outbid on Vintage Watch") {
+		t.Errorf("subject = %q", subject)
+	}
+	if text := parts["text/plain"]; !strings.Contains(text, "Hello Alice") || !strings.Contains(text, "highest bid is 125.00") {
+		t.Errorf("text part = %q", text)
+	}
+	if html := parts["text/html"]; !strings.Contains(html, "<strong>110.00</strong>") || !strings.Contains(html, "<strong>125.00</strong>") {
+		t.Errorf("html part = %q", html)
+	}
+}
+
+// TestRejectedRecipientIsDeadLettered checks that a 5xx reply to RCPT TO is
+// treated as permanent: the message is dead-lettered after one attempt
+// rather than retried.
+func TestRejectedRecipientIsDeadLettered(t *testing.T) {
+	a := newTestAuction(t)
+	a.server.RejectRecipients(&textproto.Error{Code: 550, Msg: "5.1.1 No such user"})
+
+	item := a.createItem(t, NewItem{Name: "Vintage Watch", StartingBid: 100})
+	a.bid(t, item, "Alice", "alice@example.com", 110)
+	a.bid(t, item, "Bob", "bob@example.com", 125)
+
+	var dead []QueuedMail
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		var err error
+		if dead, err = a.queue.Dead(); err != nil {
+			t.Fatal(err)
+		}
+		if len(dead) > 0 {
+			break
+		}
+	}
+	if len(dead) != 1 {
+		t.Fatalf("got %d dead letters, want 1", len(dead))
+	}
+	if got := dead[0]; got.Attempts != 1 || got.Message.To[0] != "alice@example.com" || !strings.Contains(got.LastError, "550") {
+		t.Errorf("dead letter = %+v, want one attempt to alice@example.com failing with 550", got)
+	}
+	if msgs := a.server.Messages(); len(msgs) != 0 {
+		t.Errorf("server accepted %d messages, want none", len(msgs))
+	}
+}
+
+// TestCloseNotifiesWinnerAndSeller ends an auction and checks that the
+// closer mails both the winner and the seller.
+func TestCloseNotifiesWinnerAndSeller(t *testing.T) {
+	a := newTestAuction(t)
+	item := a.createItem(t, NewItem{
+		Name:         "Vintage Watch",
+		Seller:       "Sam",
+		SellerEmail:  "sam@example.com",
+		StartingBid:  100,
+		ReservePrice: 120,
+	})
+	a.bid(t, item, "Alice", "alice@example.com", 110)
+	a.bid(t, item, "Alice", "alice@example.com", 130)
+
+	a.closeEnded(item.EndTime)
+
+	msgs, err := a.server.WaitForMessages(2, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	byRecipient := make(map[string]ReceivedMessage)
+	for _, msg := range msgs {
+		byRecipient[msg.To[0]] = msg
+	}
+
+	subject, parts := readMessage(t, byRecipient["alice@example.com"], "alice@example.com")
+	if subject != "You won Vintage Watch" {
+		t.Errorf("winner subject = %q", subject)
+	}
+	if text := parts["text/plain"]; !strings.Contains(text, "your bid of 130.00 won") || !strings.Contains(text, "The seller, Sam") {
+		t.Errorf("winner text part = %q", text)
+	}
+	if parts["text/html"] == "" {
+		t.Error("winner message has no HTML part")
+	}
+
+	_, parts = readMessage(t, byRecipient["sam@example.com"], "sam@example.com")
+	if text := parts["text/plain"]; !strings.Contains(text, "Alice <alice@example.com> won Vintage Watch with a bid of 130.00") {
+		t.Errorf("seller text part = %q", text)
+	}
+
+	closed, err := a.Item(item.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !closed.Closed || closed.Winner == nil || closed.Winner.Amount != 130 {
+		t.Errorf("item after close = %+v", closed)
+	}
+}
+
+// readMessage checks that msg went to recipient alone and returns its
+// subject and the body of each part by media type.
+func readMessage(t *testing.T, msg ReceivedMessage, recipient string) (string, map[string]string) {
+	t.Helper()
+	if len(msg.To) != 1 || msg.To[0] != recipient {
+		t.Fatalf("message sent to %v, want %s", msg.To, recipient)
+	}
+	parsed, err := msg.Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := make(map[string]string)
+	mediaType, params, err := mime.ParseMediaType(parsed.Header.Get("Content-Type"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.HasPrefix(mediaType, "multipart/") {
+		readParts(t, multipart.NewReader(parsed.Body, params["boundary"]), parts)
+	} else {
+		body, err := io.ReadAll(parsed.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		parts[mediaType] = string(body)
+	}
+	return parsed.Header.Get("Subject"), parts
+}
+
+// readParts collects the body of every leaf part by media type, descending
+// into nested multiparts.
+func readParts(t *testing.T, r *multipart.Reader, parts map[string]string) {
+	t.Helper()
+	for {
+		part, err := r.NextPart()
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		mediaType, params, err := mime.ParseMediaType(part.Header.Get("Content-Type"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.HasPrefix(mediaType, "multipart/") {
+			readParts(t, multipart.NewReader(part, params["boundary"]), parts)
+			continue
+		}
+		body, err := io.ReadAll(part)
+		if err != nil {
+			t.Fatal(err)
+		}
+		parts[mediaType] = string(body)
+	}
+}
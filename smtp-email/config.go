@@ -0,0 +1,182 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// TLS modes for the SMTP connection.
+const (
+	tlsStartTLS = "starttls"
+	tlsImplicit = "tls"
+	tlsNone     = "none"
+)
+
+type SMTPConfig struct {
+	Host     string `yaml:"host"`
+	Port     int    `yaml:"port"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	From     string `yaml:"from"`
+	TLS      string `yaml:"tls"`
+}
+
+// QueueConfig controls the durable outbound queue. Failed deliveries are
+// retried after Backoff, doubling up to MaxBackoff, and moved to the
+// dead-letter list after MaxAttempts.
+type QueueConfig struct {
+	Path        string        `yaml:"path"`
+	MaxAttempts int           `yaml:"max_attempts"`
+	Backoff     time.Duration `yaml:"backoff"`
+	MaxBackoff  time.Duration `yaml:"max_backoff"`
+}
+
+// AuctionConfig controls how auctions end. A bid placed within
+// SoftCloseWindow of the end pushes the end back to SoftCloseExtension after
+// the bid, unless the auction already ends later than that; a zero window
+// disables soft close.
+type AuctionConfig struct {
+	CloseInterval      time.Duration `yaml:"close_interval"`
+	SoftCloseWindow    time.Duration `yaml:"soft_close_window"`
+	SoftCloseExtension time.Duration `yaml:"soft_close_extension"`
+}
+
+// Store drivers for auction items.
+const (
+	storeMemory = "memory"
+	storeSQLite = "sqlite"
+)
+
+type StoreConfig struct {
+	Driver string `yaml:"driver"`
+	Path   string `yaml:"path"`
+}
+
+// TransportConfig selects how queued mail is delivered: over SMTP, or into
+// a local maildir for development.
+type TransportConfig struct {
+	Type    string `yaml:"type"`
+	Maildir string `yaml:"maildir"`
+}
+
+type Config struct {
+	Listen       string            `yaml:"listen"`
+	Transport    TransportConfig   `yaml:"transport"`
+	SMTP         SMTPConfig        `yaml:"smtp"`
+	Queue        QueueConfig       `yaml:"queue"`
+	Auction      AuctionConfig     `yaml:"auction"`
+	Store        StoreConfig       `yaml:"store"`
+	TemplatesDir string            `yaml:"templates_dir"`
+	Vars         map[string]string `yaml:"vars"`
+	// AdminToken is the bearer token required by the admin routes: item
+	// creation, bidder lookups and the dead-letter list. They are disabled
+	// when it is empty.
+	AdminToken string `yaml:"admin_token"`
+}
+
+// LoadConfig reads path, if it is not empty, and then applies SMTP_* and
+// ADMIN_TOKEN environment variables on top so secrets can stay out of the
+// file.
+func LoadConfig(path string) (*Config, error) {
+	config := &Config{
+		Listen:       ":8080",
+		TemplatesDir: "templates",
+		Transport: TransportConfig{
+			Type:    transportSMTP,
+			Maildir: "maildir",
+		},
+		SMTP: SMTPConfig{
+			Port: 587,
+			TLS:  tlsStartTLS,
+		},
+		Queue: QueueConfig{
+			Path:        "mailqueue.db",
+			MaxAttempts: 8,
+			Backoff:     30 * time.Second,
+			MaxBackoff:  time.Hour,
+		},
+		Auction: AuctionConfig{
+			CloseInterval: time.Second,
+		},
+		Store: StoreConfig{
+			Driver: storeMemory,
+			Path:   "auction.db",
+		},
+	}
+
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("error reading configuration file: %w", err)
+		}
+		if err := yaml.Unmarshal(data, config); err != nil {
+			return nil, fmt.Errorf("error parsing configuration file: %w", err)
+		}
+	}
+
+	envString("SMTP_HOST", &config.SMTP.Host)
+	envString("SMTP_USERNAME", &config.SMTP.Username)
+	envString("SMTP_PASSWORD", &config.SMTP.Password)
+	envString("SMTP_FROM", &config.SMTP.From)
+	envString("SMTP_TLS", &config.SMTP.TLS)
+	envString("ADMIN_TOKEN", &config.AdminToken)
+	if v := os.Getenv("SMTP_PORT"); v != "" {
+		port, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid SMTP_PORT %q: %w", v, err)
+		}
+		config.SMTP.Port = port
+	}
+
+	switch config.Transport.Type {
+	case transportSMTP:
+		if config.SMTP.Host == "" {
+			return nil, fmt.Errorf("smtp host is required")
+		}
+	case transportMaildir:
+		if config.Transport.Maildir == "" {
+			return nil, fmt.Errorf("transport maildir path is required")
+		}
+	default:
+		return nil, fmt.Errorf("transport type must be %s or %s", transportSMTP, transportMaildir)
+	}
+	if config.SMTP.From == "" {
+		return nil, fmt.Errorf("smtp from address is required")
+	}
+	switch config.SMTP.TLS {
+	case tlsStartTLS, tlsImplicit, tlsNone:
+	default:
+		return nil, fmt.Errorf("smtp tls must be %s, %s or %s", tlsStartTLS, tlsImplicit, tlsNone)
+	}
+	if config.Queue.MaxAttempts < 1 {
+		return nil, fmt.Errorf("queue max_attempts must be at least 1")
+	}
+	if config.Queue.Backoff <= 0 || config.Queue.MaxBackoff < config.Queue.Backoff {
+		return nil, fmt.Errorf("queue backoff must be positive and no greater than max_backoff")
+	}
+	switch config.Store.Driver {
+	case storeMemory, storeSQLite:
+	default:
+		return nil, fmt.Errorf("store driver must be %s or %s", storeMemory, storeSQLite)
+	}
+	if config.Auction.CloseInterval <= 0 {
+		return nil, fmt.Errorf("auction close_interval must be positive")
+	}
+	if config.Auction.SoftCloseWindow < 0 || config.Auction.SoftCloseExtension < 0 {
+		return nil, fmt.Errorf("auction soft close durations must not be negative")
+	}
+	if config.Auction.SoftCloseWindow > 0 && config.Auction.SoftCloseExtension == 0 {
+		config.Auction.SoftCloseExtension = config.Auction.SoftCloseWindow
+	}
+	return config, nil
+}
+
+func envString(name string, dst *string) {
+	if v := os.Getenv(name); v != "" {
+		*dst = v
+	}
+}
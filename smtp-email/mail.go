@@ -0,0 +1,23 @@
+package main
+
+// Mailer renders notification templates onto the outbound queue. Delivery
+// is left to the Sender and its Transport.
+type Mailer struct {
+	templates *Templates
+	queue     *MailQueue
+}
+
+func NewMailer(templates *Templates, queue *MailQueue) *Mailer {
+	return &Mailer{templates: templates, queue: queue}
+}
+
+// Notify renders the named template for recipient and queues it for
+// delivery.
+func (m *Mailer) Notify(name, recipient string, data TemplateData) error {
+	msg, err := m.templates.Render(name, []string{recipient}, data)
+	if err != nil {
+		return err
+	}
+	_, err = m.queue.Enqueue(msg)
+	return err
+}
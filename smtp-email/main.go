@@ -1,20 +1,39 @@
 package main
 
 import (
-	"encoding/json"
-	"fmt"
+	"context"
+	"flag"
 	"log"
 	"net/http"
-	"net/smtp"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
-	"github.com/jordan-wright/email"
+	"github.com/gorilla/mux"
 )
 
 type AuctionItem struct {
-	ID         int
-	Name       string
-	CurrentBid float64
-	BidHistory []Bid
+	ID          int
+	Name        string
+	Seller      string
+	SellerEmail string
+	CurrentBid  float64
+	BidHistory  []Bid
+	// StartTime and EndTime bound the bidding window. A zero EndTime leaves
+	// the auction open until it is closed some other way.
+	StartTime time.Time
+	EndTime   time.Time
+	// MinIncrement is how much a bid must exceed the current one by once
+	// bidding has started; the first bid only has to beat CurrentBid.
+	MinIncrement float64
+	// ReservePrice is the lowest winning bid the seller will accept.
+	ReservePrice float64
+	Closed       bool
+	Winner       *Bid
+	// Vars are extra template variables for this item's notifications.
+	// They cannot override the global ones from the config.
+	Vars map[string]string
 }
 
 type Bid struct {
@@ -22,68 +41,56 @@ type Bid struct {
 	Bidder string
 	Email  string
 	Amount float64
+	Placed time.Time
 }
 
-func sendEmail(recipient, subject, body string) error {
-	e := email.NewEmail()
-	e.From = "your_email@example.com"
-	e.To = []string{recipient}
-	e.Subject = subject
-	e.HTML = []byte(body)
-
-	smtpAddr := "smtp.example.com:587"
-	smtpAuth := smtp.PlainAuth("", "your_email@example.com", "your_password", "smtp.example.com")
+func main() {
+	configPath := flag.String("config", "", "Path to the YAML configuration file")
+	flag.Parse()
 
-	err := e.Send(smtpAddr, smtpAuth)
+	config, err := LoadConfig(*configPath)
 	if err != nil {
-		return err
+		log.Fatalf("Failed to load configuration: %v", err)
 	}
 
-	return nil
-}
+	queue, err := OpenMailQueue(config.Queue.Path)
+	if err != nil {
+		log.Fatalf("Failed to open mail queue: %v", err)
+	}
+	defer queue.Close()
 
-func handleBid(bid Bid, items map[int]*AuctionItem) {
-	item, ok := items[bid.ItemID]
-	if !ok {
-		log.Printf("Item not found: %d", bid.ItemID)
-		return
+	transport, err := NewTransport(config)
+	if err != nil {
+		log.Fatalf("Failed to set up mail transport: %v", err)
 	}
+	mailer := NewMailer(NewTemplates(config.TemplatesDir, config.Vars), queue)
 
-	item.CurrentBid = bid.Amount
-	item.BidHistory = append(item.BidHistory, bid)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go NewSender(queue, transport, config.Queue).Run(ctx)
 
-	if len(item.BidHistory) > 1 {
-		previousBid := item.BidHistory[len(item.BidHistory)-2]
-		subject := fmt.Sprintf("You've been outbid on %s", item.Name)
-		body := fmt.Sprintf("Your bid of %.2f has been outbid. The current highest bid is %.2f.", previousBid.Amount, bid.Amount)
-		err := sendEmail(previousBid.Email, subject, body)
-		if err != nil {
-			log.Printf("Error sending email to %s: %v", previousBid.Email, err)
-		}
+	repo, err := OpenRepository(config.Store)
+	if err != nil {
+		log.Fatalf("Failed to open item store: %v", err)
 	}
-}
+	defer repo.Close()
 
-func main() {
-	items := map[int]*AuctionItem{
-		1: {ID: 1, Name: "Antique Clock", CurrentBid: 100.00},
-		2: {ID: 2, Name: "Rare Painting", CurrentBid: 500.00},
-	}
+	auction := NewAuction(repo, mailer, config.Auction)
+	go auction.RunCloser(ctx)
 
-	http.HandleFunc("/bid", func(w http.ResponseWriter, r *http.Request) {
-		var bid Bid
-		err := json.NewDecoder(r.Body).Decode(&bid)
-		if err != nil {
-			http.Error(w, "Invalid bid data", http.StatusBadRequest)
-			return
-		}
+	r := mux.NewRouter()
+	registerAuctionRoutes(r, auction, config.AdminToken)
+	registerMailRoutes(r, queue, config.AdminToken)
 
-		go handleBid(bid, items)
-		w.WriteHeader(http.StatusAccepted)
-	})
+	server := &http.Server{Addr: config.Listen, Handler: r}
+	go func() {
+		<-ctx.Done()
+		server.Shutdown(context.Background())
+	}()
 
-	log.Println("Server listening on port 8080")
-	err := http.ListenAndServe(":8080", nil)
-	if err != nil {
+	log.Printf("Server listening on %s", config.Listen)
+	err = server.ListenAndServe()
+	if err != nil && err != http.ErrServerClosed {
 		log.Fatal(err)
 	}
 }
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	pendingBucket = []byte("pending")
+	deadBucket    = []byte("dead")
+)
+
+var ErrMailNotFound = errors.New("message not found")
+
+// QueuedMail is a message waiting in the outbound queue or, once it has
+// failed for good, in the dead-letter list.
+type QueuedMail struct {
+	ID          uint64     `json:"id"`
+	Message     Message    `json:"message"`
+	Queued      time.Time  `json:"queued"`
+	Attempts    int        `json:"attempts"`
+	NextAttempt time.Time  `json:"next_attempt"`
+	LastError   string     `json:"last_error,omitempty"`
+	Failed      *time.Time `json:"failed,omitempty"`
+}
+
+// MailQueue persists outbound mail in a bbolt database so notifications
+// survive SMTP outages and restarts.
+type MailQueue struct {
+	db *bolt.DB
+	// wake is signalled whenever new mail becomes due, so the sender does
+	// not have to wait for its next poll.
+	wake chan struct{}
+}
+
+func OpenMailQueue(path string) (*MailQueue, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open mail queue: %w", err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(pendingBucket); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucketIfNotExists(deadBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &MailQueue{db: db, wake: make(chan struct{}, 1)}, nil
+}
+
+func (q *MailQueue) Close() error {
+	return q.db.Close()
+}
+
+func mailKey(id uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, id)
+	return key
+}
+
+func putMail(b *bolt.Bucket, mail QueuedMail) error {
+	data, err := json.Marshal(mail)
+	if err != nil {
+		return err
+	}
+	return b.Put(mailKey(mail.ID), data)
+}
+
+func (q *MailQueue) notify() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Enqueue stores msg for immediate delivery.
+func (q *MailQueue) Enqueue(msg Message) (QueuedMail, error) {
+	now := time.Now()
+	mail := QueuedMail{Message: msg, Queued: now, NextAttempt: now}
+	err := q.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(pendingBucket)
+		id, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+		mail.ID = id
+		return putMail(b, mail)
+	})
+	if err != nil {
+		return QueuedMail{}, fmt.Errorf("failed to queue message: %w", err)
+	}
+	q.notify()
+	return mail, nil
+}
+
+// Due returns the pending messages whose next attempt is at or before now,
+// oldest first.
+func (q *MailQueue) Due(now time.Time) ([]QueuedMail, error) {
+	var due []QueuedMail
+	err := q.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(pendingBucket).ForEach(func(k, v []byte) error {
+			var mail QueuedMail
+			if err := json.Unmarshal(v, &mail); err != nil {
+				return err
+			}
+			if !mail.NextAttempt.After(now) {
+				due = append(due, mail)
+			}
+			return nil
+		})
+	})
+	return due, err
+}
+
+// NextDue returns the earliest next attempt among pending messages.
+func (q *MailQueue) NextDue() (time.Time, bool, error) {
+	var next time.Time
+	var ok bool
+	err := q.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(pendingBucket).ForEach(func(k, v []byte) error {
+			var mail QueuedMail
+			if err := json.Unmarshal(v, &mail); err != nil {
+				return err
+			}
+			if !ok || mail.NextAttempt.Before(next) {
+				next, ok = mail.NextAttempt, true
+			}
+			return nil
+		})
+	})
+	return next, ok, err
+}
+
+// Delivered removes a successfully sent message from the queue.
+func (q *MailQueue) Delivered(id uint64) error {
+	return q.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(pendingBucket).Delete(mailKey(id))
+	})
+}
+
+// Retry records a failed attempt and reschedules the message for next.
+func (q *MailQueue) Retry(mail QueuedMail, sendErr error, next time.Time) error {
+	mail.LastError = sendErr.Error()
+	mail.NextAttempt = next
+	return q.db.Update(func(tx *bolt.Tx) error {
+		return putMail(tx.Bucket(pendingBucket), mail)
+	})
+}
+
+// Bury moves a message that will never be delivered to the dead-letter list.
+func (q *MailQueue) Bury(mail QueuedMail, sendErr error) error {
+	mail.LastError = sendErr.Error()
+	now := time.Now()
+	mail.Failed = &now
+	return q.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.Bucket(pendingBucket).Delete(mailKey(mail.ID)); err != nil {
+			return err
+		}
+		return putMail(tx.Bucket(deadBucket), mail)
+	})
+}
+
+// Dead returns the dead-letter list, oldest first.
+func (q *MailQueue) Dead() ([]QueuedMail, error) {
+	dead := []QueuedMail{}
+	err := q.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(deadBucket).ForEach(func(k, v []byte) error {
+			var mail QueuedMail
+			if err := json.Unmarshal(v, &mail); err != nil {
+				return err
+			}
+			dead = append(dead, mail)
+			return nil
+		})
+	})
+	return dead, err
+}
+
+// Replay moves a dead message back onto the queue with a fresh attempt
+// count. It keeps its ID.
+func (q *MailQueue) Replay(id uint64) (QueuedMail, error) {
+	var mail QueuedMail
+	err := q.db.Update(func(tx *bolt.Tx) error {
+		dead := tx.Bucket(deadBucket)
+		data := dead.Get(mailKey(id))
+		if data == nil {
+			return ErrMailNotFound
+		}
+		if err := json.Unmarshal(data, &mail); err != nil {
+			return err
+		}
+		mail.Attempts = 0
+		mail.NextAttempt = time.Now()
+		mail.Failed = nil
+		if err := dead.Delete(mailKey(id)); err != nil {
+			return err
+		}
+		return putMail(tx.Bucket(pendingBucket), mail)
+	})
+	if err != nil {
+		return QueuedMail{}, err
+	}
+	q.notify()
+	return mail, nil
+}
+
+// Discard deletes a message from the dead-letter list.
+func (q *MailQueue) Discard(id uint64) error {
+	return q.db.Update(func(tx *bolt.Tx) error {
+		dead := tx.Bucket(deadBucket)
+		if dead.Get(mailKey(id)) == nil {
+			return ErrMailNotFound
+		}
+		return dead.Delete(mailKey(id))
+	})
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// ItemRepository stores auction items and their bids. Implementations return
+// copies, so callers may modify what they get back without affecting the
+// stored item. The Auction serializes writes, so implementations only need
+// to be safe for concurrent reads alongside a single writer.
+type ItemRepository interface {
+	// Create stores a new item and returns it with its assigned ID.
+	Create(item AuctionItem) (AuctionItem, error)
+	// Get returns the item with the given ID or ErrItemNotFound.
+	Get(id int) (AuctionItem, error)
+	// List returns every item ordered by ID.
+	List() ([]AuctionItem, error)
+	// RecordBid saves the item's new state together with the bid that
+	// produced it.
+	RecordBid(item AuctionItem, bid Bid) error
+	// Update saves the item's state, such as its end time or result.
+	Update(item AuctionItem) error
+	Close() error
+}
+
+// OpenRepository returns the repository selected by config.
+func OpenRepository(config StoreConfig) (ItemRepository, error) {
+	if config.Driver == storeSQLite {
+		return NewSQLiteRepository(config.Path)
+	}
+	return NewMemoryRepository(), nil
+}
+
+// memoryRepository keeps items in a map; everything is lost on restart.
+type memoryRepository struct {
+	mu     sync.RWMutex
+	items  map[int]*AuctionItem
+	nextID int
+}
+
+func NewMemoryRepository() ItemRepository {
+	return &memoryRepository{items: make(map[int]*AuctionItem), nextID: 1}
+}
+
+func (r *memoryRepository) Create(item AuctionItem) (AuctionItem, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	item.ID = r.nextID
+	r.nextID++
+	stored := item.snapshot()
+	r.items[item.ID] = &stored
+	return stored.snapshot(), nil
+}
+
+func (r *memoryRepository) Get(id int) (AuctionItem, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	item, ok := r.items[id]
+	if !ok {
+		return AuctionItem{}, fmt.Errorf("%w: %d", ErrItemNotFound, id)
+	}
+	return item.snapshot(), nil
+}
+
+func (r *memoryRepository) List() ([]AuctionItem, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	items := make([]AuctionItem, 0, len(r.items))
+	for _, item := range r.items {
+		items = append(items, item.snapshot())
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].ID < items[j].ID })
+	return items, nil
+}
+
+func (r *memoryRepository) RecordBid(item AuctionItem, bid Bid) error {
+	return r.Update(item)
+}
+
+func (r *memoryRepository) Update(item AuctionItem) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.items[item.ID]; !ok {
+		return fmt.Errorf("%w: %d", ErrItemNotFound, item.ID)
+	}
+	stored := item.snapshot()
+	r.items[item.ID] = &stored
+	return nil
+}
+
+func (r *memoryRepository) Close() error {
+	return nil
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/textproto"
+	"time"
+)
+
+// Sender drains the mail queue, retrying failed deliveries with exponential
+// backoff and burying messages that exhaust their attempts or are rejected
+// outright by the server.
+type Sender struct {
+	queue     *MailQueue
+	transport Transport
+
+	MaxAttempts int
+	Backoff     time.Duration
+	MaxBackoff  time.Duration
+	// Poll bounds how long the sender sleeps between queue scans.
+	Poll time.Duration
+}
+
+func NewSender(queue *MailQueue, transport Transport, config QueueConfig) *Sender {
+	return &Sender{
+		queue:       queue,
+		transport:   transport,
+		MaxAttempts: config.MaxAttempts,
+		Backoff:     config.Backoff,
+		MaxBackoff:  config.MaxBackoff,
+		Poll:        30 * time.Second,
+	}
+}
+
+// Run delivers mail until ctx is cancelled.
+func (s *Sender) Run(ctx context.Context) {
+	for {
+		s.drain(ctx)
+
+		wait := s.Poll
+		if next, ok, err := s.queue.NextDue(); err != nil {
+			log.Printf("Error reading mail queue: %v", err)
+		} else if ok {
+			if d := time.Until(next); d < wait {
+				wait = d
+			}
+		}
+		if wait <= 0 {
+			continue
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-s.queue.wake:
+			timer.Stop()
+		case <-timer.C:
+		}
+	}
+}
+
+func (s *Sender) drain(ctx context.Context) {
+	due, err := s.queue.Due(time.Now())
+	if err != nil {
+		log.Printf("Error reading mail queue: %v", err)
+		return
+	}
+	for _, mail := range due {
+		if ctx.Err() != nil {
+			return
+		}
+		s.deliver(mail)
+	}
+}
+
+func (s *Sender) deliver(mail QueuedMail) {
+	mail.Attempts++
+	err := s.transport.Send(mail.Message)
+	if err == nil {
+		if err := s.queue.Delivered(mail.ID); err != nil {
+			log.Printf("Error removing delivered message %d from queue: %v", mail.ID, err)
+		}
+		return
+	}
+
+	if permanent(err) || mail.Attempts >= s.MaxAttempts {
+		log.Printf("Giving up on message %d to %v after %d attempt(s): %v", mail.ID, mail.Message.To, mail.Attempts, err)
+		if err := s.queue.Bury(mail, err); err != nil {
+			log.Printf("Error moving message %d to dead letters: %v", mail.ID, err)
+		}
+		return
+	}
+
+	next := time.Now().Add(s.backoff(mail.Attempts))
+	log.Printf("Error sending message %d to %v (attempt %d), retrying at %s: %v",
+		mail.ID, mail.Message.To, mail.Attempts, next.Format(time.RFC3339), err)
+	if err := s.queue.Retry(mail, err, next); err != nil {
+		log.Printf("Error rescheduling message %d: %v", mail.ID, err)
+	}
+}
+
+// backoff returns the delay after the given number of failed attempts,
+// doubling from Backoff up to MaxBackoff.
+func (s *Sender) backoff(attempts int) time.Duration {
+	d := s.Backoff
+	for i := 1; i < attempts; i++ {
+		d *= 2
+		if d >= s.MaxBackoff {
+			return s.MaxBackoff
+		}
+	}
+	return d
+}
+
+// permanent reports whether the SMTP server rejected the message itself
+// (550-554, e.g. unknown mailbox), in which case retrying will not help.
+// Other 5xx replies such as authentication failures are usually
+// configuration problems that get fixed, so those are retried.
+func permanent(err error) bool {
+	var tpErr *textproto.Error
+	return errors.As(err, &tpErr) && tpErr.Code >= 550 && tpErr.Code <= 554
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ReceivedMessage is a message accepted by a TestSMTPServer.
+type ReceivedMessage struct {
+	From string
+	To   []string
+	Data []byte
+}
+
+// Parse parses the raw message so tests can check headers and the body.
+func (m ReceivedMessage) Parse() (*mail.Message, error) {
+	return mail.ReadMessage(bytes.NewReader(m.Data))
+}
+
+// TestSMTPServer is a minimal in-process SMTP server for tests. It speaks
+// plain SMTP without TLS, accepts any AUTH PLAIN credentials and keeps every
+// message in memory. Point SMTPConfig at Host and Port with TLS set to none.
+type TestSMTPServer struct {
+	Host string
+	Port int
+
+	listener net.Listener
+	mu       sync.Mutex
+	reject   *textproto.Error
+	messages []ReceivedMessage
+	received chan struct{}
+	wg       sync.WaitGroup
+}
+
+// StartTestSMTPServer listens on a random loopback port.
+func StartTestSMTPServer() (*TestSMTPServer, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("failed to start test SMTP server: %w", err)
+	}
+	addr := l.Addr().(*net.TCPAddr)
+	s := &TestSMTPServer{
+		Host:     addr.IP.String(),
+		Port:     addr.Port,
+		listener: l,
+		received: make(chan struct{}, 1),
+	}
+	s.wg.Add(1)
+	go s.serve()
+	return s, nil
+}
+
+// Config returns SMTP settings that deliver to the server.
+func (s *TestSMTPServer) Config(from string) SMTPConfig {
+	return SMTPConfig{Host: s.Host, Port: s.Port, From: from, TLS: tlsNone}
+}
+
+// RejectRecipients makes the server answer every RCPT TO with err, so tests
+// can exercise retries and dead-lettering. A nil err accepts them again.
+func (s *TestSMTPServer) RejectRecipients(err *textproto.Error) {
+	s.mu.Lock()
+	s.reject = err
+	s.mu.Unlock()
+}
+
+// Messages returns a copy of everything received so far.
+func (s *TestSMTPServer) Messages() []ReceivedMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]ReceivedMessage(nil), s.messages...)
+}
+
+// WaitForMessages blocks until at least n messages have arrived or timeout
+// passes, and returns what was received.
+func (s *TestSMTPServer) WaitForMessages(n int, timeout time.Duration) ([]ReceivedMessage, error) {
+	deadline := time.After(timeout)
+	for {
+		if msgs := s.Messages(); len(msgs) >= n {
+			return msgs, nil
+		}
+		select {
+		case <-s.received:
+		case <-deadline:
+			msgs := s.Messages()
+			return msgs, fmt.Errorf("received %d of %d messages before timeout", len(msgs), n)
+		}
+	}
+}
+
+// Close stops the server and waits for open sessions to finish.
+func (s *TestSMTPServer) Close() error {
+	err := s.listener.Close()
+	s.wg.Wait()
+	return err
+}
+
+func (s *TestSMTPServer) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(time.Minute))
+			s.session(conn)
+		}()
+	}
+}
+
+func (s *TestSMTPServer) session(conn io.ReadWriter) {
+	r := textproto.NewReader(bufio.NewReader(conn))
+	w := textproto.NewWriter(bufio.NewWriter(conn))
+	reply := func(format string, args ...interface{}) bool {
+		return w.PrintfLine(format, args...) == nil
+	}
+
+	var from string
+	var to []string
+	if !reply("220 localhost test SMTP server ready") {
+		return
+	}
+	for {
+		line, err := r.ReadLine()
+		if err != nil {
+			return
+		}
+		verb, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(verb) {
+		case "HELO":
+			reply("250 localhost")
+		case "EHLO":
+			reply("250-localhost\r\n250-8BITMIME\r\n250 AUTH PLAIN")
+		case "AUTH":
+			reply("235 2.7.0 Authentication successful")
+		case "MAIL":
+			from, to = addressArg(arg), nil
+			reply("250 2.1.0 OK")
+		case "RCPT":
+			s.mu.Lock()
+			reject := s.reject
+			s.mu.Unlock()
+			if reject != nil {
+				reply("%d %s", reject.Code, reject.Msg)
+				continue
+			}
+			to = append(to, addressArg(arg))
+			reply("250 2.1.5 OK")
+		case "DATA":
+			if from == "" || len(to) == 0 {
+				reply("503 5.5.1 Bad sequence of commands")
+				continue
+			}
+			reply("354 End data with <CR><LF>.<CR><LF>")
+			data, err := io.ReadAll(r.DotReader())
+			if err != nil {
+				return
+			}
+			s.mu.Lock()
+			s.messages = append(s.messages, ReceivedMessage{From: from, To: to, Data: data})
+			s.mu.Unlock()
+			select {
+			case s.received <- struct{}{}:
+			default:
+			}
+			from, to = "", nil
+			reply("250 2.0.0 OK")
+		case "RSET":
+			from, to = "", nil
+			reply("250 2.0.0 OK")
+		case "NOOP":
+			reply("250 2.0.0 OK")
+		case "QUIT":
+			reply("221 2.0.0 Bye")
+			return
+		default:
+			reply("502 5.5.2 Command not implemented")
+		}
+	}
+}
+
+// addressArg extracts the address from "FROM:<a@b>" or "TO:<a@b>".
+func addressArg(arg string) string {
+	_, addr, _ := strings.Cut(arg, ":")
+	addr, _, _ = strings.Cut(strings.TrimSpace(addr), " ")
+	return strings.Trim(addr, "<>")
+}
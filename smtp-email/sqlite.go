@@ -0,0 +1,226 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS items (
+	id            INTEGER PRIMARY KEY AUTOINCREMENT,
+	name          TEXT NOT NULL,
+	seller        TEXT NOT NULL,
+	seller_email  TEXT NOT NULL,
+	current_bid   REAL NOT NULL,
+	start_time    DATETIME NOT NULL,
+	end_time      DATETIME NOT NULL,
+	min_increment REAL NOT NULL,
+	reserve_price REAL NOT NULL,
+	closed        BOOLEAN NOT NULL DEFAULT 0,
+	winner        TEXT,
+	vars          TEXT
+);
+CREATE TABLE IF NOT EXISTS bids (
+	id      INTEGER PRIMARY KEY AUTOINCREMENT,
+	item_id INTEGER NOT NULL REFERENCES items(id),
+	bidder  TEXT NOT NULL,
+	email   TEXT NOT NULL,
+	amount  REAL NOT NULL,
+	placed  DATETIME NOT NULL
+);
+CREATE INDEX IF NOT EXISTS bids_item ON bids (item_id, id);
+`
+
+// sqliteRepository persists items and their bid history in SQLite. The
+// winner and per-item template variables are stored as JSON.
+type sqliteRepository struct {
+	db *sql.DB
+}
+
+func NewSQLiteRepository(path string) (ItemRepository, error) {
+	db, err := sql.Open("sqlite3", path+"?_foreign_keys=on&_busy_timeout=5000")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open item database: %w", err)
+	}
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create item tables: %w", err)
+	}
+	return &sqliteRepository{db: db}, nil
+}
+
+func (r *sqliteRepository) Close() error {
+	return r.db.Close()
+}
+
+func encodeNullJSON(v interface{}, empty bool) (sql.NullString, error) {
+	if empty {
+		return sql.NullString{}, nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return sql.NullString{}, err
+	}
+	return sql.NullString{String: string(data), Valid: true}, nil
+}
+
+func (r *sqliteRepository) Create(item AuctionItem) (AuctionItem, error) {
+	vars, err := encodeNullJSON(item.Vars, len(item.Vars) == 0)
+	if err != nil {
+		return AuctionItem{}, err
+	}
+	res, err := r.db.Exec(`INSERT INTO items
+		(name, seller, seller_email, current_bid, start_time, end_time, min_increment, reserve_price, vars)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		item.Name, item.Seller, item.SellerEmail, item.CurrentBid, item.StartTime, item.EndTime,
+		item.MinIncrement, item.ReservePrice, vars)
+	if err != nil {
+		return AuctionItem{}, fmt.Errorf("failed to create item: %w", err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return AuctionItem{}, err
+	}
+	item.ID = int(id)
+	item.BidHistory = nil
+	return item, nil
+}
+
+const selectItem = `SELECT id, name, seller, seller_email, current_bid, start_time, end_time,
+	min_increment, reserve_price, closed, winner, vars FROM items`
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanItem(row rowScanner) (AuctionItem, error) {
+	var item AuctionItem
+	var winner, vars sql.NullString
+	err := row.Scan(&item.ID, &item.Name, &item.Seller, &item.SellerEmail, &item.CurrentBid,
+		&item.StartTime, &item.EndTime, &item.MinIncrement, &item.ReservePrice, &item.Closed,
+		&winner, &vars)
+	if err != nil {
+		return AuctionItem{}, err
+	}
+	if winner.Valid {
+		if err := json.Unmarshal([]byte(winner.String), &item.Winner); err != nil {
+			return AuctionItem{}, fmt.Errorf("invalid winner for item %d: %w", item.ID, err)
+		}
+	}
+	if vars.Valid {
+		if err := json.Unmarshal([]byte(vars.String), &item.Vars); err != nil {
+			return AuctionItem{}, fmt.Errorf("invalid vars for item %d: %w", item.ID, err)
+		}
+	}
+	return item, nil
+}
+
+func (r *sqliteRepository) Get(id int) (AuctionItem, error) {
+	item, err := scanItem(r.db.QueryRow(selectItem+" WHERE id = ?", id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return AuctionItem{}, fmt.Errorf("%w: %d", ErrItemNotFound, id)
+	}
+	if err != nil {
+		return AuctionItem{}, fmt.Errorf("failed to load item %d: %w", id, err)
+	}
+	bids, err := r.bids("WHERE item_id = ?", id)
+	if err != nil {
+		return AuctionItem{}, err
+	}
+	item.BidHistory = bids[id]
+	return item, nil
+}
+
+func (r *sqliteRepository) List() ([]AuctionItem, error) {
+	rows, err := r.db.Query(selectItem + " ORDER BY id")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list items: %w", err)
+	}
+	defer rows.Close()
+
+	items := []AuctionItem{}
+	for rows.Next() {
+		item, err := scanItem(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list items: %w", err)
+		}
+		items = append(items, item)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list items: %w", err)
+	}
+
+	bids, err := r.bids("")
+	if err != nil {
+		return nil, err
+	}
+	for i := range items {
+		items[i].BidHistory = bids[items[i].ID]
+	}
+	return items, nil
+}
+
+// bids loads bid histories keyed by item ID, in the order they were placed.
+func (r *sqliteRepository) bids(where string, args ...interface{}) (map[int][]Bid, error) {
+	rows, err := r.db.Query("SELECT item_id, bidder, email, amount, placed FROM bids "+where+" ORDER BY id", args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load bids: %w", err)
+	}
+	defer rows.Close()
+
+	bids := make(map[int][]Bid)
+	for rows.Next() {
+		var bid Bid
+		if err := rows.Scan(&bid.ItemID, &bid.Bidder, &bid.Email, &bid.Amount, &bid.Placed); err != nil {
+			return nil, fmt.Errorf("failed to load bids: %w", err)
+		}
+		bids[bid.ItemID] = append(bids[bid.ItemID], bid)
+	}
+	return bids, rows.Err()
+}
+
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+func updateItem(db execer, item AuctionItem) error {
+	winner, err := encodeNullJSON(item.Winner, item.Winner == nil)
+	if err != nil {
+		return err
+	}
+	res, err := db.Exec(`UPDATE items SET current_bid = ?, end_time = ?, closed = ?, winner = ? WHERE id = ?`,
+		item.CurrentBid, item.EndTime, item.Closed, winner, item.ID)
+	if err != nil {
+		return fmt.Errorf("failed to update item %d: %w", item.ID, err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("%w: %d", ErrItemNotFound, item.ID)
+	}
+	return nil
+}
+
+func (r *sqliteRepository) Update(item AuctionItem) error {
+	return updateItem(r.db, item)
+}
+
+func (r *sqliteRepository) RecordBid(item AuctionItem, bid Bid) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to record bid: %w", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`INSERT INTO bids (item_id, bidder, email, amount, placed) VALUES (?, ?, ?, ?, ?)`,
+		bid.ItemID, bid.Bidder, bid.Email, bid.Amount, bid.Placed)
+	if err != nil {
+		return fmt.Errorf("failed to record bid: %w", err)
+	}
+	if err := updateItem(tx, item); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	htmltemplate "html/template"
+	"os"
+	"path/filepath"
+	"strings"
+	texttemplate "text/template"
+)
+
+// Message is a rendered email with a plain-text and an HTML alternative.
+type Message struct {
+	To      []string
+	Subject string
+	Text    string
+	HTML    string
+}
+
+// Templates renders notifications from <dir>/<name>.txt and <dir>/<name>.html.
+// The text template must define a "subject" block, which becomes the subject
+// line; the HTML template is optional.
+type Templates struct {
+	dir  string
+	vars map[string]string
+}
+
+func NewTemplates(dir string, vars map[string]string) *Templates {
+	return &Templates{dir: dir, vars: vars}
+}
+
+// TemplateData is what templates see. Vars holds the global variables from
+// the config plus the item's own; an item cannot override a global, so
+// settings such as the signature always come from the config. Winner is set for closing
+// notifications and is nil when the item did not sell.
+type TemplateData struct {
+	Item        *AuctionItem
+	Bid         Bid
+	PreviousBid Bid
+	Winner      *Bid
+	Vars        map[string]string
+}
+
+// Render executes the named template pair. Templates are parsed on every
+// call, so edits take effect without a restart.
+func (t *Templates) Render(name string, to []string, data TemplateData) (Message, error) {
+	vars := make(map[string]string, len(t.vars))
+	if data.Item != nil {
+		for k, v := range data.Item.Vars {
+			vars[k] = v
+		}
+	}
+	for k, v := range t.vars {
+		vars[k] = v
+	}
+	data.Vars = vars
+
+	msg := Message{To: to}
+
+	text, err := texttemplate.ParseFiles(filepath.Join(t.dir, name+".txt"))
+	if err != nil {
+		return Message{}, fmt.Errorf("failed to parse %s text template: %w", name, err)
+	}
+	var buf bytes.Buffer
+	if err := text.ExecuteTemplate(&buf, "subject", data); err != nil {
+		return Message{}, fmt.Errorf("failed to render %s subject: %w", name, err)
+	}
+	msg.Subject = strings.TrimSpace(buf.String())
+	buf.Reset()
+	if err := text.Execute(&buf, data); err != nil {
+		return Message{}, fmt.Errorf("failed to render %s text: %w", name, err)
+	}
+	msg.Text = strings.TrimSpace(buf.String()) + "\n"
+
+	htmlPath := filepath.Join(t.dir, name+".html")
+	if _, err := os.Stat(htmlPath); os.IsNotExist(err) {
+		return msg, nil
+	}
+	html, err := htmltemplate.ParseFiles(htmlPath)
+	if err != nil {
+		return Message{}, fmt.Errorf("failed to parse %s HTML template: %w", name, err)
+	}
+	buf.Reset()
+	if err := html.Execute(&buf, data); err != nil {
+		return Message{}, fmt.Errorf("failed to render %s HTML: %w", name, err)
+	}
+	msg.HTML = buf.String()
+	return msg, nil
+}
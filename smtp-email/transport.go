@@ -0,0 +1,117 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/jordan-wright/email"
+)
+
+// Transport delivers a rendered message.
+type Transport interface {
+	Send(msg Message) error
+}
+
+// Transport types.
+const (
+	transportSMTP    = "smtp"
+	transportMaildir = "maildir"
+)
+
+// NewTransport returns the transport selected by config.
+func NewTransport(config *Config) (Transport, error) {
+	switch config.Transport.Type {
+	case transportSMTP:
+		return &SMTPTransport{config: config.SMTP}, nil
+	case transportMaildir:
+		return NewMaildirTransport(config.Transport.Maildir, config.SMTP.From)
+	}
+	return nil, fmt.Errorf("unknown transport %q", config.Transport.Type)
+}
+
+func newEmail(from string, msg Message) *email.Email {
+	e := email.NewEmail()
+	e.From = from
+	e.To = msg.To
+	e.Subject = msg.Subject
+	e.Text = []byte(msg.Text)
+	if msg.HTML != "" {
+		e.HTML = []byte(msg.HTML)
+	}
+	return e
+}
+
+// SMTPTransport sends multipart/alternative email through an SMTP server.
+type SMTPTransport struct {
+	config SMTPConfig
+}
+
+func (t *SMTPTransport) Send(msg Message) error {
+	e := newEmail(t.config.From, msg)
+
+	addr := net.JoinHostPort(t.config.Host, strconv.Itoa(t.config.Port))
+	var auth smtp.Auth
+	if t.config.Username != "" {
+		auth = smtp.PlainAuth("", t.config.Username, t.config.Password, t.config.Host)
+	}
+	tlsConfig := &tls.Config{ServerName: t.config.Host}
+
+	var err error
+	switch t.config.TLS {
+	case tlsImplicit:
+		err = e.SendWithTLS(addr, auth, tlsConfig)
+	case tlsStartTLS:
+		err = e.SendWithStartTLS(addr, auth, tlsConfig)
+	default:
+		err = e.Send(addr, auth)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to send email to %v: %w", msg.To, err)
+	}
+	return nil
+}
+
+// MaildirTransport writes each message into a maildir for local
+// development, where any mail client can open it.
+type MaildirTransport struct {
+	dir  string
+	from string
+	seq  atomic.Uint64
+}
+
+func NewMaildirTransport(dir, from string) (*MaildirTransport, error) {
+	for _, sub := range []string{"tmp", "new", "cur"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0700); err != nil {
+			return nil, fmt.Errorf("failed to create maildir: %w", err)
+		}
+	}
+	return &MaildirTransport{dir: dir, from: from}, nil
+}
+
+// Send follows the maildir delivery protocol: write to tmp, then rename into
+// new so readers never see a partial message.
+func (t *MaildirTransport) Send(msg Message) error {
+	data, err := newEmail(t.from, msg).Bytes()
+	if err != nil {
+		return fmt.Errorf("failed to build email: %w", err)
+	}
+
+	host, _ := os.Hostname()
+	name := fmt.Sprintf("%d.M%dP%dQ%d.%s", time.Now().Unix(), time.Now().Nanosecond()/1000, os.Getpid(), t.seq.Add(1), host)
+	tmp := filepath.Join(t.dir, "tmp", name)
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to write message: %w", err)
+	}
+	if err := os.Rename(tmp, filepath.Join(t.dir, "new", name)); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to deliver message: %w", err)
+	}
+	return nil
+}
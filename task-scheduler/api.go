@@ -0,0 +1,244 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// newAPIRouter exposes the scheduler over HTTP/JSON. The API can run any
+// command, so every request must carry token as a bearer token, even on
+// loopback. When listening on loopback the Host header must name a loopback
+// host too, which keeps DNS rebinding pages out.
+func newAPIRouter(s *Scheduler, listenAddr, token string) *mux.Router {
+	r := mux.NewRouter()
+	if loopbackAddr(listenAddr) {
+		r.Use(loopbackHost)
+	}
+	r.Use(bearerAuth(token))
+
+	r.HandleFunc("/tasks", listTasks(s)).Methods("GET")
+	r.HandleFunc("/tasks", addTask(s)).Methods("POST")
+	r.HandleFunc("/tasks/{name}", getTask(s)).Methods("GET")
+	r.HandleFunc("/tasks/{name}", removeTask(s)).Methods("DELETE")
+	r.HandleFunc("/tasks/{name}/pause", setPaused(s, true)).Methods("POST")
+	r.HandleFunc("/tasks/{name}/resume", setPaused(s, false)).Methods("POST")
+	r.HandleFunc("/tasks/{name}/run", triggerTask(s)).Methods("POST")
+	r.HandleFunc("/tasks/{name}/history", taskHistory(s)).Methods("GET")
+	return r
+}
+
+// loadOrCreateToken returns the API token stored in path, generating and
+// saving a new one, readable only by the owner, if the file does not exist.
+func loadOrCreateToken(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err == nil {
+		token := strings.TrimSpace(string(data))
+		if token == "" {
+			return "", fmt.Errorf("token file %s is empty", path)
+		}
+		return token, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return "", err
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(b)
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return "", err
+	}
+	if _, err := f.WriteString(token + "\n"); err != nil {
+		f.Close()
+		os.Remove(path)
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		return "", err
+	}
+	log.Printf("Generated a management API token in %s", path)
+	return token, nil
+}
+
+// loopbackAddr reports whether a listen address only accepts connections
+// from this machine. An empty host listens on every interface.
+func loopbackAddr(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil || host == "" {
+		return false
+	}
+	return loopbackHostname(host)
+}
+
+func loopbackHostname(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// loopbackHost rejects requests whose Host header is not a loopback name or
+// address.
+func loopbackHost(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.Host)
+		if err != nil {
+			host = r.Host
+		}
+		if !loopbackHostname(strings.Trim(host, "[]")) {
+			http.Error(w, "Invalid host", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func bearerAuth(token string) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeError maps scheduler errors onto HTTP status codes.
+func writeError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrInvalidTask):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, ErrTaskNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, ErrTaskExists), errors.Is(err, ErrTaskInUse):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func listTasks(s *Scheduler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, s.Tasks())
+	}
+}
+
+func getTask(s *Scheduler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := mux.Vars(r)["name"]
+		task, ok := s.Task(name)
+		if !ok {
+			writeError(w, ErrTaskNotFound)
+			return
+		}
+		writeJSON(w, http.StatusOK, task)
+	}
+}
+
+func addTask(s *Scheduler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != "application/json" {
+			http.Error(w, "Content-Type must be application/json", http.StatusUnsupportedMediaType)
+			return
+		}
+		var def Task
+		if err := json.NewDecoder(r.Body).Decode(&def); err != nil {
+			http.Error(w, "Invalid task definition", http.StatusBadRequest)
+			return
+		}
+		task, err := s.AddTask(def)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusCreated, task)
+	}
+}
+
+func removeTask(s *Scheduler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := s.RemoveTask(mux.Vars(r)["name"]); err != nil {
+			writeError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func setPaused(s *Scheduler, paused bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		task, err := s.SetPaused(mux.Vars(r)["name"], paused)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, task)
+	}
+}
+
+func triggerTask(s *Scheduler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := s.Trigger(mux.Vars(r)["name"]); err != nil {
+			writeError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
+
+func taskHistory(s *Scheduler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := mux.Vars(r)["name"]
+		if _, ok := s.Task(name); !ok {
+			writeError(w, ErrTaskNotFound)
+			return
+		}
+
+		limit := 20
+		if v := r.URL.Query().Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				http.Error(w, "Invalid limit", http.StatusBadRequest)
+				return
+			}
+			limit = n
+		}
+
+		runs, err := s.History(name, limit)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		if runs == nil {
+			runs = []Run{}
+		}
+		writeJSON(w, http.StatusOK, runs)
+	}
+}
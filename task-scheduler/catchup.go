@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// Catch-up policies decide what happens on startup to fires that were missed
+// while the scheduler was down.
+const (
+	catchUpNone = "none"
+	catchUpOnce = "once"
+	catchUpAll  = "all"
+)
+
+// maxCatchUp bounds the number of missed fires replayed by the "all" policy,
+// so a frequent task does not run thousands of times after a long outage.
+const maxCatchUp = 100
+
+// cronSpec returns the schedule as passed to cron, prefixed with the task's
+// time zone if it has one.
+func (t *Task) cronSpec() string {
+	if t.TimeZone == "" {
+		return t.Schedule
+	}
+	return "CRON_TZ=" + t.TimeZone + " " + t.Schedule
+}
+
+func (t *Task) validateTiming() error {
+	if len(t.DependsOn) > 0 {
+		if t.TimeZone != "" || (t.CatchUp != "" && t.CatchUp != catchUpNone) {
+			return fmt.Errorf("task %q: timezone and catch_up apply to scheduled tasks only; dependent tasks follow their upstreams", t.Name)
+		}
+		return nil
+	}
+	if t.TimeZone != "" {
+		if strings.HasPrefix(t.Schedule, "CRON_TZ=") || strings.HasPrefix(t.Schedule, "TZ=") {
+			return fmt.Errorf("task %q: set either timezone or a CRON_TZ= prefix, not both", t.Name)
+		}
+		if _, err := time.LoadLocation(t.TimeZone); err != nil {
+			return fmt.Errorf("task %q: invalid timezone %q: %w", t.Name, t.TimeZone, err)
+		}
+	}
+	switch t.CatchUp {
+	case "", catchUpNone, catchUpOnce, catchUpAll:
+	default:
+		return fmt.Errorf("task %q: catch_up must be %s, %s or %s", t.Name, catchUpNone, catchUpOnce, catchUpAll)
+	}
+	return nil
+}
+
+// scheduledFire is the cron callback of a task: it records the fire time so
+// that missed fires can be detected after a restart, then starts a cycle.
+func (s *Scheduler) scheduledFire(task *Task) {
+	s.mu.Lock()
+	fired := s.cron.Entry(cron.EntryID(task.ID)).Prev
+	s.mu.Unlock()
+	if fired.IsZero() {
+		fired = time.Now().Truncate(time.Second)
+	}
+
+	if err := s.history.SetLastFire(task.Name, fired); err != nil {
+		log.Printf("Failed to record fire time of task %s: %v", task.Name, err)
+	}
+	s.startCycle(task, false)
+}
+
+// CatchUp runs the fires each task missed since its last recorded fire,
+// according to its catch_up policy. It is meant to be called once at
+// startup, before the cron scheduler starts.
+func (s *Scheduler) CatchUp() {
+	now := time.Now()
+	for _, task := range s.scheduled() {
+		if task.CatchUp == "" || task.CatchUp == catchUpNone {
+			continue
+		}
+
+		last, ok, err := s.history.LastFire(task.Name)
+		if err != nil {
+			log.Printf("Failed to read fire time of task %s: %v", task.Name, err)
+			continue
+		}
+		if !ok {
+			continue
+		}
+
+		sched, err := cron.ParseStandard(task.cronSpec())
+		if err != nil {
+			continue
+		}
+		missed, total := missedFires(sched, last, now, maxCatchUp)
+		if total == 0 {
+			continue
+		}
+		if task.CatchUp == catchUpOnce {
+			log.Printf("Task %s missed %d fire(s) since %s, running once (catch_up=%s)", task.Name, total, last.Format(time.RFC3339), task.CatchUp)
+			missed = missed[len(missed)-1:]
+		} else if total > len(missed) {
+			log.Printf("Task %s missed %d fire(s) since %s, running the last %d (catch_up=%s)", task.Name, total, last.Format(time.RFC3339), len(missed), task.CatchUp)
+		} else {
+			log.Printf("Task %s missed %d fire(s) since %s, running each (catch_up=%s)", task.Name, total, last.Format(time.RFC3339), task.CatchUp)
+		}
+
+		go s.replay(task, missed)
+	}
+}
+
+// replay runs the missed fires of task one after the other.
+func (s *Scheduler) replay(task *Task, fires []time.Time) {
+	for _, fired := range fires {
+		log.Printf("Catching up task %s for %s", task.Name, fired.Format(time.RFC3339))
+		if err := s.history.SetLastFire(task.Name, fired); err != nil {
+			log.Printf("Failed to record fire time of task %s: %v", task.Name, err)
+		}
+		s.startCycle(task, false)
+	}
+}
+
+// scheduled returns the tasks that have a cron entry.
+func (s *Scheduler) scheduled() []*Task {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var tasks []*Task
+	for _, task := range s.tasks {
+		if len(task.DependsOn) == 0 {
+			tasks = append(tasks, task)
+		}
+	}
+	return tasks
+}
+
+// missedFires counts the fire times of sched after last and up to now, and
+// returns the most recent keep of them in order.
+func missedFires(sched cron.Schedule, last, now time.Time, keep int) ([]time.Time, int) {
+	var fires []time.Time
+	total := 0
+	for t := sched.Next(last); !t.IsZero() && !t.After(now); t = sched.Next(t) {
+		total++
+		if len(fires) == keep {
+			fires = append(fires[:0], fires[1:]...)
+		}
+		fires = append(fires, t)
+	}
+	return fires, total
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+// What a dependent task does when one of its upstreams fails in a cycle.
+const (
+	upstreamFailureSkip = "skip"
+	upstreamFailureRun  = "run"
+)
+
+// validateGraph checks the depends_on edges between tasks: every dependency
+// must exist and be listed once, the graph must be acyclic, and each dependent task must trace
+// back to exactly one scheduled task, whose firing defines the cycle it runs
+// in.
+func validateGraph(tasks []Task) error {
+	byName := make(map[string]*Task, len(tasks))
+	for i := range tasks {
+		byName[tasks[i].Name] = &tasks[i]
+	}
+	for _, task := range tasks {
+		seen := make(map[string]bool, len(task.DependsOn))
+		for _, dep := range task.DependsOn {
+			if _, ok := byName[dep]; !ok {
+				return fmt.Errorf("task %q depends on unknown task %q", task.Name, dep)
+			}
+			if seen[dep] {
+				return fmt.Errorf("task %q lists dependency %q more than once", task.Name, dep)
+			}
+			seen[dep] = true
+		}
+	}
+
+	const (
+		visiting = 1
+		visited  = 2
+	)
+	state := make(map[string]int, len(tasks))
+	roots := make(map[string]map[string]bool, len(tasks))
+
+	var visit func(name string, path []string) error
+	visit = func(name string, path []string) error {
+		switch state[name] {
+		case visiting:
+			return fmt.Errorf("dependency cycle: %s", strings.Join(append(path, name), " -> "))
+		case visited:
+			return nil
+		}
+		state[name] = visiting
+
+		task := byName[name]
+		roots[name] = make(map[string]bool)
+		if len(task.DependsOn) == 0 {
+			roots[name][name] = true
+		}
+		for _, dep := range task.DependsOn {
+			if err := visit(dep, append(path, name)); err != nil {
+				return err
+			}
+			for root := range roots[dep] {
+				roots[name][root] = true
+			}
+		}
+		if len(roots[name]) > 1 {
+			names := make([]string, 0, len(roots[name]))
+			for root := range roots[name] {
+				names = append(names, root)
+			}
+			sort.Strings(names)
+			return fmt.Errorf("task %q depends on tasks from different schedules (%s)", name, strings.Join(names, ", "))
+		}
+
+		state[name] = visited
+		return nil
+	}
+
+	for _, task := range tasks {
+		if err := visit(task.Name, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// outcome is how a task ended in a cycle.
+type outcome int
+
+const (
+	outcomeSucceeded outcome = iota
+	outcomeFailed
+	// outcomeSkipped means the task did not run at all: it was paused, its
+	// overlap policy turned the run away, or one of its upstreams was
+	// skipped.
+	outcomeSkipped
+)
+
+// join collects the outcomes of a dependent task's upstreams in one cycle,
+// keyed by upstream so each is only counted once.
+type join struct {
+	done    map[string]bool
+	failed  []string
+	skipped []string
+}
+
+// startCycle runs task as the first step of a new cycle. Its downstream
+// tasks then run as their upstreams complete. Scheduled cycles of a paused
+// task are skipped, but a manual trigger always runs.
+//
+// A dependent task triggered by hand runs on its own: its other upstreams
+// will not report into a cycle it starts, so fanning out from it would
+// leave downstream tasks waiting forever.
+func (s *Scheduler) startCycle(task *Task, manual bool) {
+	s.mu.Lock()
+	paused := task.Paused
+	s.mu.Unlock()
+
+	if paused && !manual {
+		log.Printf("Skipping paused task %s", task.Name)
+		return
+	}
+	if len(task.DependsOn) > 0 {
+		log.Printf("Task %s is running outside its DAG; downstream tasks will not follow", task.Name)
+		s.runTask(task)
+		return
+	}
+	cycle := fmt.Sprintf("%s@%d", task.Name, time.Now().UnixNano())
+	out := s.runTask(task)
+	if out == outcomeSkipped {
+		// Nothing ran, so there is no cycle for downstream tasks to follow.
+		return
+	}
+	s.upstreamFinished(task, cycle, out)
+}
+
+// runInCycle runs a dependent task once its upstreams are done.
+func (s *Scheduler) runInCycle(task *Task, cycle string) {
+	s.mu.Lock()
+	paused := task.Paused
+	s.mu.Unlock()
+
+	if paused {
+		log.Printf("Skipping paused task %s", task.Name)
+		s.upstreamFinished(task, cycle, outcomeSkipped)
+		return
+	}
+	s.upstreamFinished(task, cycle, s.runTask(task))
+}
+
+// upstreamFinished records the outcome of task in cycle and starts every
+// downstream task whose upstreams have now all finished. A downstream task
+// is skipped if an upstream failed, unless on_upstream_failure is run, and
+// always if an upstream was skipped, since that part of the cycle never
+// happened.
+func (s *Scheduler) upstreamFinished(task *Task, cycle string, out outcome) {
+	type skip struct {
+		task   *Task
+		reason string
+	}
+	var ready []*Task
+	var skipped []skip
+
+	s.mu.Lock()
+	for _, down := range s.downstream(task.Name) {
+		key := cycle + "/" + down.Name
+		j, found := s.joins[key]
+		if !found {
+			j = &join{done: make(map[string]bool)}
+			s.joins[key] = j
+		}
+		if j.done[task.Name] {
+			continue
+		}
+		j.done[task.Name] = true
+		switch out {
+		case outcomeFailed:
+			j.failed = append(j.failed, task.Name)
+		case outcomeSkipped:
+			j.skipped = append(j.skipped, task.Name)
+		}
+		if len(j.done) < len(down.DependsOn) {
+			continue
+		}
+
+		delete(s.joins, key)
+		switch {
+		case len(j.failed) > 0 && down.OnUpstreamFailure != upstreamFailureRun:
+			skipped = append(skipped, skip{down, fmt.Sprintf("upstream %s did not succeed", strings.Join(j.failed, ", "))})
+		case len(j.skipped) > 0:
+			skipped = append(skipped, skip{down, fmt.Sprintf("upstream %s was skipped", strings.Join(j.skipped, ", "))})
+		default:
+			ready = append(ready, down)
+		}
+	}
+	s.mu.Unlock()
+
+	for _, down := range ready {
+		log.Printf("Task %s starting after its upstreams finished (cycle %s)", down.Name, cycle)
+		go s.runInCycle(down, cycle)
+	}
+	for _, sk := range skipped {
+		log.Printf("Skipping task %s: %s (cycle %s)", sk.task.Name, sk.reason, cycle)
+		s.recordSkip(sk.task, sk.reason)
+		go s.upstreamFinished(sk.task, cycle, outcomeSkipped)
+	}
+}
+
+// downstream returns the tasks that depend on name; s.mu must be held.
+func (s *Scheduler) downstream(name string) []*Task {
+	var tasks []*Task
+	for _, task := range s.tasks {
+		for _, dep := range task.DependsOn {
+			if dep == name {
+				tasks = append(tasks, task)
+				break
+			}
+		}
+	}
+	return tasks
+}
+
+func (s *Scheduler) recordSkip(task *Task, reason string) {
+	now := time.Now()
+	run := Run{Task: task.Name, Start: now, End: now, ExitCode: -1, Skipped: reason}
+	if err := s.history.Record(run); err != nil {
+		log.Printf("Failed to record run of task %s: %v", task.Name, err)
+	}
+
+	s.mu.Lock()
+	task.LastRun = run.Start
+	task.LastStatus = run.Status()
+	s.mu.Unlock()
+}
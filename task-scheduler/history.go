@@ -0,0 +1,194 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	runsBucket  = []byte("runs")
+	firesBucket = []byte("fires")
+)
+
+// Run is the record of a single task execution.
+type Run struct {
+	Task     string        `json:"task"`
+	Attempt  int           `json:"attempt"`
+	Start    time.Time     `json:"start"`
+	End      time.Time     `json:"end"`
+	ExitCode int           `json:"exit_code"`
+	Duration time.Duration `json:"duration"`
+	Error    string        `json:"error,omitempty"`
+	Skipped  string        `json:"skipped,omitempty"`
+	Stdout   string        `json:"stdout,omitempty"`
+	Stderr   string        `json:"stderr,omitempty"`
+	Tail     string        `json:"output_tail,omitempty"`
+}
+
+// Status renders the run the same way Task.LastStatus does.
+func (r Run) Status() string {
+	if r.Skipped != "" {
+		return fmt.Sprintf("Skipped: %s", r.Skipped)
+	}
+	if r.Error != "" {
+		return fmt.Sprintf("Failed: %s", r.Error)
+	}
+	return "Success"
+}
+
+// History persists runs in a bbolt database, one nested bucket per task with
+// keys that sort in the order the runs were recorded.
+type History struct {
+	db *bolt.DB
+
+	// Keep is the number of runs kept per task; older runs are dropped as
+	// new ones are recorded. Zero keeps every run.
+	Keep int
+}
+
+func OpenHistory(path string) (*History, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open history database: %w", err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(runsBucket); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucketIfNotExists(firesBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &History{db: db}, nil
+}
+
+// OpenHistoryReadOnly opens the database for reading only. bbolt still
+// locks the file, so this fails while a scheduler has it open.
+func OpenHistoryReadOnly(path string) (*History, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second, ReadOnly: true})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open history database: %w", err)
+	}
+	return &History{db: db}, nil
+}
+
+func (h *History) Close() error {
+	return h.db.Close()
+}
+
+// Record appends run to the history of its task.
+func (h *History) Record(run Run) error {
+	return h.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.Bucket(runsBucket).CreateBucketIfNotExists([]byte(run.Task))
+		if err != nil {
+			return err
+		}
+		seq, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+		data, err := json.Marshal(run)
+		if err != nil {
+			return err
+		}
+		key := make([]byte, 8)
+		binary.BigEndian.PutUint64(key, seq)
+		if err := b.Put(key, data); err != nil {
+			return err
+		}
+
+		// Keys are sequence numbers, so everything at or below seq-Keep is
+		// older than the runs being kept.
+		if h.Keep <= 0 || seq <= uint64(h.Keep) {
+			return nil
+		}
+		c := b.Cursor()
+		for k, _ := c.First(); k != nil && binary.BigEndian.Uint64(k) <= seq-uint64(h.Keep); k, _ = c.First() {
+			if err := c.Delete(); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Runs returns up to limit runs of task, newest first. A limit of zero or
+// less returns every run.
+func (h *History) Runs(task string, limit int) ([]Run, error) {
+	var runs []Run
+	err := h.db.View(func(tx *bolt.Tx) error {
+		all := tx.Bucket(runsBucket)
+		if all == nil {
+			return nil
+		}
+		b := all.Bucket([]byte(task))
+		if b == nil {
+			return nil
+		}
+		c := b.Cursor()
+		for k, v := c.Last(); k != nil; k, v = c.Prev() {
+			var run Run
+			if err := json.Unmarshal(v, &run); err != nil {
+				return err
+			}
+			runs = append(runs, run)
+			if limit > 0 && len(runs) >= limit {
+				break
+			}
+		}
+		return nil
+	})
+	return runs, err
+}
+
+// Last returns the most recent run of task, if there is one.
+func (h *History) Last(task string) (Run, bool, error) {
+	runs, err := h.Runs(task, 1)
+	if err != nil || len(runs) == 0 {
+		return Run{}, false, err
+	}
+	return runs[0], true, nil
+}
+
+// SetLastFire records the time task was last due according to its schedule.
+// The recorded time only ever moves forward: catch-up replays older fires
+// while the live schedule is already running, and must not make a restart
+// replay fires that have been run.
+func (h *History) SetLastFire(task string, t time.Time) error {
+	data, err := t.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	return h.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(firesBucket)
+		if old := b.Get([]byte(task)); old != nil {
+			var last time.Time
+			if err := last.UnmarshalBinary(old); err == nil && !t.After(last) {
+				return nil
+			}
+		}
+		return b.Put([]byte(task), data)
+	})
+}
+
+// LastFire returns the time recorded by SetLastFire, if any.
+func (h *History) LastFire(task string) (time.Time, bool, error) {
+	var t time.Time
+	var ok bool
+	err := h.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(firesBucket).Get([]byte(task))
+		if data == nil {
+			return nil
+		}
+		ok = true
+		return t.UnmarshalBinary(data)
+	})
+	return t, ok, err
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/robfig/cron/v3"
+	"gopkg.in/yaml.v3"
+)
+
+// Overlap policies decide what happens when a task is due while a previous
+// run is still going.
+const (
+	overlapAllow = "allow"
+	overlapSkip  = "skip"
+	overlapQueue = "queue"
+)
+
+// defaultBackoff is the delay before the first retry when a task sets
+// retries without retry_backoff.
+const defaultBackoff = 10 * time.Second
+
+// Duration is a time.Duration written as a string such as "90s" or "10m",
+// both in the jobs file and in the API.
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	return d.parse(s)
+}
+
+func (d *Duration) UnmarshalYAML(value *yaml.Node) error {
+	var s string
+	if err := value.Decode(&s); err != nil {
+		return err
+	}
+	return d.parse(s)
+}
+
+func (d *Duration) parse(s string) error {
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// taskName restricts names to characters that are safe in file paths, since
+// each task's output is stored in a directory named after it.
+var taskName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+type jobsFile struct {
+	Notifiers map[string]ChannelConfig `yaml:"notifiers"`
+	Tasks     []Task                   `yaml:"tasks"`
+}
+
+// loadJobs reads and validates the notifiers and task definitions in path.
+func loadJobs(path string) (*jobsFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var file jobsFile
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+
+	seen := make(map[string]bool)
+	for i, task := range file.Tasks {
+		if err := task.validate(); err != nil {
+			return nil, fmt.Errorf("task %d: %w", i, err)
+		}
+		if seen[task.Name] {
+			return nil, fmt.Errorf("task %q: duplicate name", task.Name)
+		}
+		seen[task.Name] = true
+
+		for _, name := range task.Notify.channels() {
+			if _, ok := file.Notifiers[name]; !ok {
+				return nil, fmt.Errorf("task %q: unknown notifier %q", task.Name, name)
+			}
+		}
+	}
+	if err := validateGraph(file.Tasks); err != nil {
+		return nil, err
+	}
+	return &file, nil
+}
+
+// validate checks the fields every task definition needs, wherever it came
+// from.
+func (t *Task) validate() error {
+	if t.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	if !taskName.MatchString(t.Name) {
+		return fmt.Errorf("task %q: name may only contain letters, digits, '.', '-' and '_'", t.Name)
+	}
+	if t.Command == "" {
+		return fmt.Errorf("task %q: command is required", t.Name)
+	}
+	if err := t.validateTiming(); err != nil {
+		return err
+	}
+	if len(t.DependsOn) > 0 {
+		if t.Schedule != "" {
+			return fmt.Errorf("task %q: tasks with depends_on run after their upstreams and cannot have a schedule", t.Name)
+		}
+	} else if _, err := cron.ParseStandard(t.cronSpec()); err != nil {
+		return fmt.Errorf("task %q: invalid schedule %q: %w", t.Name, t.Schedule, err)
+	}
+	if t.Notify != nil && t.Notify.LongRunningAfter < 0 {
+		return fmt.Errorf("task %q: long_running_after must not be negative", t.Name)
+	}
+	switch t.OnUpstreamFailure {
+	case "", upstreamFailureSkip, upstreamFailureRun:
+	default:
+		return fmt.Errorf("task %q: on_upstream_failure must be %s or %s", t.Name, upstreamFailureSkip, upstreamFailureRun)
+	}
+	if t.Timeout < 0 || t.Backoff < 0 {
+		return fmt.Errorf("task %q: timeout and retry_backoff must not be negative", t.Name)
+	}
+	if t.Retries < 0 {
+		return fmt.Errorf("task %q: retries must not be negative", t.Name)
+	}
+	switch t.Overlap {
+	case "", overlapAllow, overlapSkip, overlapQueue:
+	default:
+		return fmt.Errorf("task %q: overlap must be %s, %s or %s", t.Name, overlapAllow, overlapSkip, overlapQueue)
+	}
+	return nil
+}
+
+// watchJobs calls reload whenever the jobs file changes. The parent directory
+// is watched rather than the file itself so that editors which save by
+// renaming a temporary file over the original are picked up too.
+func watchJobs(path string, reload func()) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	if err := watcher.Add(filepath.Dir(path)); err != nil {
+		watcher.Close()
+		return err
+	}
+
+	name := filepath.Clean(path)
+	go func() {
+		defer watcher.Close()
+
+		// Editors often emit several events per save; wait for them to settle.
+		var debounce <-chan time.Time
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if filepath.Clean(event.Name) != name {
+					continue
+				}
+				if event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename|fsnotify.Remove) != 0 {
+					debounce = time.After(500 * time.Millisecond)
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				log.Printf("Jobs file watcher error: %v", err)
+			case <-debounce:
+				debounce = nil
+				reload()
+			}
+		}
+	}()
+	return nil
+}
@@ -1,84 +1,238 @@
 package main
 
 import (
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log"
+	"net"
+	"net/http"
+	"net/url"
 	"os"
-	"os/exec"
+	"strings"
+	"text/tabwriter"
 	"time"
 
 	"github.com/robfig/cron/v3"
+	"gopkg.in/natefinch/lumberjack.v2"
 )
 
 type Task struct {
-	ID         int
-	Schedule   string
-	Command    string
-	Args       []string
-	LastRun    time.Time
-	LastStatus string
+	ID                int               `yaml:"-" json:"id"`
+	Name              string            `yaml:"name" json:"name"`
+	Schedule          string            `yaml:"schedule" json:"schedule"`
+	Command           string            `yaml:"command" json:"command"`
+	Args              []string          `yaml:"args" json:"args,omitempty"`
+	Env               map[string]string `yaml:"env" json:"env,omitempty"`
+	Dir               string            `yaml:"dir" json:"dir,omitempty"`
+	Timeout           Duration          `yaml:"timeout" json:"timeout,omitempty"`
+	Retries           int               `yaml:"retries" json:"retries,omitempty"`
+	Backoff           Duration          `yaml:"retry_backoff" json:"retry_backoff,omitempty"`
+	Overlap           string            `yaml:"overlap" json:"overlap,omitempty"`
+	DependsOn         []string          `yaml:"depends_on" json:"depends_on,omitempty"`
+	OnUpstreamFailure string            `yaml:"on_upstream_failure" json:"on_upstream_failure,omitempty"`
+	Notify            *NotifySettings   `yaml:"notify" json:"notify,omitempty"`
+	TimeZone          string            `yaml:"timezone" json:"timezone,omitempty"`
+	CatchUp           string            `yaml:"catch_up" json:"catch_up,omitempty"`
+	Source            string            `yaml:"-" json:"source"`
+	Paused            bool              `yaml:"-" json:"paused"`
+	NextRun           time.Time         `yaml:"-" json:"next_run"`
+	PrevRun           time.Time         `yaml:"-" json:"prev_run"`
+	LastRun           time.Time         `yaml:"-" json:"last_run"`
+	LastStatus        string            `yaml:"-" json:"last_status"`
 }
 
-var tasks []Task
-var taskID int
-var logFile *os.File
+// Where a task definition came from. Reloading the jobs file only touches
+// tasks that were defined there.
+const (
+	sourceFile = "file"
+	sourceAPI  = "api"
+)
+
+// definition returns a copy of the task with its runtime state cleared, so
+// two tasks can be compared by what the jobs file says about them.
+func (t Task) definition() Task {
+	t.ID = 0
+	t.Source = ""
+	t.Paused = false
+	t.NextRun = time.Time{}
+	t.PrevRun = time.Time{}
+	t.LastRun = time.Time{}
+	t.LastStatus = ""
+	return t
+}
+
+var logFile *lumberjack.Logger
 
 func main() {
 	logFileName := flag.String("log", "task-scheduler.log", "Log file path")
+	jobsFileName := flag.String("jobs", "jobs.yaml", "Task definitions file")
+	dbFileName := flag.String("db", "task-scheduler.db", "Run history database path")
+	showHistory := flag.String("history", "", "Print the run history of the named task and exit")
+	historyLimit := flag.Int("limit", 20, "Number of runs printed by -history")
+	historyKeep := flag.Int("history-keep", 1000, "Runs kept per task in the history database (0 to keep all)")
+	listenAddr := flag.String("listen", "127.0.0.1:8080", "Address of the management API (empty to disable). The API can run arbitrary commands and always requires the API token")
+	outputDir := flag.String("output-dir", "task-output", "Directory for per-run stdout/stderr files (empty to discard output)")
+	outputMaxAge := flag.Duration("output-max-age", 7*24*time.Hour, "Delete run output older than this (0 to keep forever)")
+	outputMaxSize := flag.Int64("output-max-size", 100, "Maximum run output kept per task, in megabytes (0 for no limit)")
+	logMaxSize := flag.Int("log-max-size", 10, "Rotate the scheduler log after this many megabytes")
+	logMaxBackups := flag.Int("log-max-backups", 5, "Number of rotated scheduler logs to keep")
+	logMaxAge := flag.Int("log-max-age", 30, "Delete rotated scheduler logs older than this many days")
+	notifyRepeat := flag.Duration("notify-repeat", 6*time.Hour, "Repeat failure notifications for a task that keeps failing this often (0 to notify once per failure streak)")
+	apiToken := flag.String("api-token", os.Getenv("TASK_SCHEDULER_TOKEN"), "Bearer token required by the management API (default $TASK_SCHEDULER_TOKEN, otherwise read from -api-token-file)")
+	apiTokenFile := flag.String("api-token-file", "task-scheduler.token", "File holding the API token when -api-token is not set; one is generated on first start")
 	flag.Parse()
 
-	var err error
-	logFile, err = os.OpenFile(*logFileName, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if *showHistory != "" {
+		runs, err := readHistory(*showHistory, *historyLimit, *dbFileName, *listenAddr, *apiToken, *apiTokenFile)
+		if err != nil {
+			log.Fatalf("Failed to read run history: %v", err)
+		}
+		printHistory(*showHistory, runs)
+		return
+	}
+
+	history, err := OpenHistory(*dbFileName)
 	if err != nil {
-		log.Fatalf("Failed to open log file: %v", err)
+		log.Fatalf("Failed to open run history: %v", err)
+	}
+	defer history.Close()
+	history.Keep = *historyKeep
+
+	if *listenAddr != "" && *apiToken == "" {
+		*apiToken, err = loadOrCreateToken(*apiTokenFile)
+		if err != nil {
+			log.Fatalf("Failed to load API token: %v", err)
+		}
+	}
+
+	logFile = &lumberjack.Logger{
+		Filename:   *logFileName,
+		MaxSize:    *logMaxSize,
+		MaxBackups: *logMaxBackups,
+		MaxAge:     *logMaxAge,
 	}
 	defer logFile.Close()
 
 	log.SetOutput(logFile)
 
 	c := cron.New()
-	scheduleTasks(c)
+	output := &OutputStore{
+		Dir:     *outputDir,
+		MaxAge:  *outputMaxAge,
+		MaxSize: *outputMaxSize << 20,
+	}
+	notifier := NewNotifier(*notifyRepeat)
+	scheduler := NewScheduler(c, history, output, notifier)
+
+	jobs, err := loadJobs(*jobsFileName)
+	if err != nil {
+		log.Fatalf("Failed to load jobs: %v", err)
+	}
+	if err := notifier.SetChannels(jobs.Notifiers); err != nil {
+		log.Fatalf("Failed to configure notifiers: %v", err)
+	}
+	scheduler.Reconcile(jobs.Tasks)
+
+	err = watchJobs(*jobsFileName, func() {
+		jobs, err := loadJobs(*jobsFileName)
+		if err == nil {
+			err = notifier.SetChannels(jobs.Notifiers)
+		}
+		if err != nil {
+			log.Printf("Keeping current tasks, failed to reload jobs: %v", err)
+			return
+		}
+		scheduler.Reconcile(jobs.Tasks)
+	})
+	if err != nil {
+		log.Fatalf("Failed to watch jobs file: %v", err)
+	}
+
+	scheduler.CatchUp()
 	c.Start()
 
-	select {}
+	if *listenAddr == "" {
+		select {}
+	}
+	log.Printf("Management API listening on %s", *listenAddr)
+	if err := http.ListenAndServe(*listenAddr, newAPIRouter(scheduler, *listenAddr, *apiToken)); err != nil {
+		log.Fatalf("Management API failed: %v", err)
+	}
 }
 
-func scheduleTasks(c *cron.Cron) {
-	addTask(c, "@every 1m", "echo", []string{"Hello, World!"})
-	addTask(c, "0 0 * * *", "backup.sh", []string{"/path/to/backup"})
+// readHistory returns the runs printed by -history. A running scheduler
+// holds an exclusive lock on the database, so its API is asked first, and
+// the database is only opened, read-only, when no scheduler answers.
+func readHistory(task string, limit int, dbPath, listenAddr, token, tokenFile string) ([]Run, error) {
+	if listenAddr != "" {
+		if token == "" {
+			if data, err := os.ReadFile(tokenFile); err == nil {
+				token = strings.TrimSpace(string(data))
+			}
+		}
+		runs, err := fetchHistory(listenAddr, token, task, limit)
+		if !errors.Is(err, errNoDaemon) {
+			return runs, err
+		}
+	}
+
+	history, err := OpenHistoryReadOnly(dbPath)
+	if err != nil {
+		return nil, err
+	}
+	defer history.Close()
+	return history.Runs(task, limit)
 }
 
-func addTask(c *cron.Cron, schedule, command string, args []string) {
-	task := Task{
-		ID:       taskID,
-		Schedule: schedule,
-		Command:  command,
-		Args:     args,
+var errNoDaemon = errors.New("scheduler is not running")
+
+// fetchHistory reads the runs of task from the management API at
+// listenAddr, returning errNoDaemon if nothing is listening there.
+func fetchHistory(listenAddr, token, task string, limit int) ([]Run, error) {
+	host, port, err := net.SplitHostPort(listenAddr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid listen address %q: %w", listenAddr, err)
+	}
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		host = "127.0.0.1"
 	}
-	taskID++
-	tasks = append(tasks, task)
+	u := fmt.Sprintf("http://%s/tasks/%s/history?limit=%d", net.JoinHostPort(host, port), url.PathEscape(task), limit)
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
 
-	entryID, err := c.AddFunc(schedule, func() {
-		runTask(&task)
-	})
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
 	if err != nil {
-		log.Printf("Failed to schedule task %d: %v", task.ID, err)
-		return
+		return nil, fmt.Errorf("%w: %v", errNoDaemon, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("scheduler API returned %s: %s", resp.Status, strings.TrimSpace(string(msg)))
 	}
-	task.ID = int(entryID)
+	var runs []Run
+	if err := json.NewDecoder(resp.Body).Decode(&runs); err != nil {
+		return nil, fmt.Errorf("failed to decode history: %w", err)
+	}
+	return runs, nil
 }
 
-func runTask(task *Task) {
-	log.Printf("Running task %d: %s %v", task.ID, task.Command, task.Args)
-	cmd := exec.Command(task.Command, task.Args...)
-	err := cmd.Run()
-	task.LastRun = time.Now()
-	if err != nil {
-		task.LastStatus = fmt.Sprintf("Failed: %v", err)
-		log.Printf("Task %d failed: %v", task.ID, err)
-	} else {
-		task.LastStatus = "Success"
-		log.Printf("Task %d completed successfully", task.ID)
+func printHistory(task string, runs []Run) {
+	if len(runs) == 0 {
+		fmt.Printf("No runs recorded for task %s\n", task)
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "START\tDURATION\tEXIT\tSTATUS")
+	for _, run := range runs {
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\n", run.Start.Format(time.RFC3339), run.Duration.Round(time.Millisecond), run.ExitCode, run.Status())
 	}
+	w.Flush()
 }
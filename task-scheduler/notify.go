@@ -0,0 +1,384 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/smtp"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Notification events.
+const (
+	eventFailure     = "failure"
+	eventRecovery    = "recovery"
+	eventLongRunning = "long_running"
+)
+
+// Channel types that can appear in the notifiers section of the jobs file.
+const (
+	channelWebhook = "webhook"
+	channelEmail   = "email"
+	channelCommand = "command"
+)
+
+// ChannelConfig describes one notification channel. Which fields are used
+// depends on Type. String values may reference environment variables, e.g.
+// password: ${SMTP_PASSWORD}.
+type ChannelConfig struct {
+	Type string `yaml:"type"`
+
+	// webhook
+	URL     string            `yaml:"url"`
+	Headers map[string]string `yaml:"headers"`
+
+	// email
+	Host     string   `yaml:"host"`
+	Port     int      `yaml:"port"`
+	Username string   `yaml:"username"`
+	Password string   `yaml:"password"`
+	From     string   `yaml:"from"`
+	To       []string `yaml:"to"`
+
+	// command
+	Command string   `yaml:"command"`
+	Args    []string `yaml:"args"`
+}
+
+// NotifySettings chooses the channels a task notifies for each event.
+type NotifySettings struct {
+	OnFailure        []string `yaml:"on_failure" json:"on_failure,omitempty"`
+	OnRecovery       []string `yaml:"on_recovery" json:"on_recovery,omitempty"`
+	OnLongRunning    []string `yaml:"on_long_running" json:"on_long_running,omitempty"`
+	LongRunningAfter Duration `yaml:"long_running_after" json:"long_running_after,omitempty"`
+}
+
+// channels returns every channel name referenced by the settings.
+func (n *NotifySettings) channels() []string {
+	if n == nil {
+		return nil
+	}
+	var names []string
+	names = append(names, n.OnFailure...)
+	names = append(names, n.OnRecovery...)
+	names = append(names, n.OnLongRunning...)
+	return names
+}
+
+// Event is the payload delivered to every channel.
+type Event struct {
+	Event   string    `json:"event"`
+	Task    string    `json:"task"`
+	Time    time.Time `json:"time"`
+	Message string    `json:"message"`
+	Run     *Run      `json:"run,omitempty"`
+}
+
+type channel interface {
+	Send(e Event) error
+}
+
+// Notifier delivers task events to the configured channels. Failure
+// notifications are sent once per failure streak and then only every repeat
+// interval while the task keeps failing; a recovery notification ends the
+// streak.
+type Notifier struct {
+	mu       sync.Mutex
+	channels map[string]channel
+	repeat   time.Duration
+	failing  map[string]time.Time
+}
+
+func NewNotifier(repeat time.Duration) *Notifier {
+	return &Notifier{
+		channels: make(map[string]channel),
+		repeat:   repeat,
+		failing:  make(map[string]time.Time),
+	}
+}
+
+// SetChannels replaces the configured channels.
+func (n *Notifier) SetChannels(configs map[string]ChannelConfig) error {
+	channels := make(map[string]channel, len(configs))
+	for name, cfg := range configs {
+		ch, err := newChannel(cfg)
+		if err != nil {
+			return fmt.Errorf("notifier %q: %w", name, err)
+		}
+		channels[name] = ch
+	}
+
+	n.mu.Lock()
+	n.channels = channels
+	n.mu.Unlock()
+	return nil
+}
+
+// Check reports an error if settings reference a channel that is not
+// configured.
+func (n *Notifier) Check(settings *NotifySettings) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for _, name := range settings.channels() {
+		if _, ok := n.channels[name]; !ok {
+			return fmt.Errorf("unknown notifier %q", name)
+		}
+	}
+	return nil
+}
+
+// Restore seeds the failure streak of a task from its last recorded run, so
+// a restart does not re-announce a failure that was already reported. It is
+// called whenever a task is (re)loaded, so an existing streak is left alone:
+// resetting it on every jobs file reload would keep postponing the repeat
+// notification.
+func (n *Notifier) Restore(task string, last Run) {
+	if last.Error == "" {
+		return
+	}
+	n.mu.Lock()
+	if _, ok := n.failing[task]; !ok {
+		n.failing[task] = last.End
+	}
+	n.mu.Unlock()
+}
+
+// RunFinished is called with the final run of a task, after any retries.
+func (n *Notifier) RunFinished(task *Task, run Run) {
+	n.mu.Lock()
+	lastSent, failing := n.failing[task.Name]
+	var event string
+	switch {
+	case run.Error == "" && failing:
+		delete(n.failing, task.Name)
+		event = eventRecovery
+	case run.Error != "" && !failing:
+		n.failing[task.Name] = run.End
+		event = eventFailure
+	case run.Error != "" && n.repeat > 0 && run.End.Sub(lastSent) >= n.repeat:
+		n.failing[task.Name] = run.End
+		event = eventFailure
+	case run.Error != "":
+		log.Printf("Task %s is still failing, notification suppressed", task.Name)
+	}
+	n.mu.Unlock()
+
+	switch event {
+	case eventFailure:
+		n.send(task.Notify.onFailure(), Event{
+			Event:   eventFailure,
+			Task:    task.Name,
+			Time:    run.End,
+			Message: fmt.Sprintf("Task %s failed: %s", task.Name, run.Error),
+			Run:     &run,
+		})
+	case eventRecovery:
+		n.send(task.Notify.onRecovery(), Event{
+			Event:   eventRecovery,
+			Task:    task.Name,
+			Time:    run.End,
+			Message: fmt.Sprintf("Task %s recovered", task.Name),
+			Run:     &run,
+		})
+	}
+}
+
+// WatchLongRunning sends a long-running notification if the run started at
+// start is still going after the task's threshold. The returned function
+// must be called when the run ends.
+func (n *Notifier) WatchLongRunning(task *Task, start time.Time) func() {
+	if task.Notify == nil || task.Notify.LongRunningAfter <= 0 || len(task.Notify.OnLongRunning) == 0 {
+		return func() {}
+	}
+	after := time.Duration(task.Notify.LongRunningAfter)
+	timer := time.AfterFunc(after, func() {
+		n.send(task.Notify.OnLongRunning, Event{
+			Event:   eventLongRunning,
+			Task:    task.Name,
+			Time:    time.Now(),
+			Message: fmt.Sprintf("Task %s has been running for more than %s (started %s)", task.Name, after, start.Format(time.RFC3339)),
+		})
+	})
+	return func() { timer.Stop() }
+}
+
+func (s *NotifySettings) onFailure() []string {
+	if s == nil {
+		return nil
+	}
+	return s.OnFailure
+}
+
+func (s *NotifySettings) onRecovery() []string {
+	if s == nil {
+		return nil
+	}
+	return s.OnRecovery
+}
+
+// send delivers e to the named channels in the background.
+func (n *Notifier) send(names []string, e Event) {
+	if len(names) == 0 {
+		return
+	}
+	n.mu.Lock()
+	targets := make(map[string]channel, len(names))
+	for _, name := range names {
+		if ch, ok := n.channels[name]; ok {
+			targets[name] = ch
+		} else {
+			log.Printf("Notifier %s is not configured, dropping %s event for task %s", name, e.Event, e.Task)
+		}
+	}
+	n.mu.Unlock()
+
+	for name, ch := range targets {
+		go func(name string, ch channel) {
+			if err := ch.Send(e); err != nil {
+				log.Printf("Failed to send %s notification for task %s via %s: %v", e.Event, e.Task, name, err)
+				return
+			}
+			log.Printf("Sent %s notification for task %s via %s", e.Event, e.Task, name)
+		}(name, ch)
+	}
+}
+
+func newChannel(cfg ChannelConfig) (channel, error) {
+	switch cfg.Type {
+	case channelWebhook:
+		if cfg.URL == "" {
+			return nil, fmt.Errorf("webhook url is required")
+		}
+		headers := make(map[string]string, len(cfg.Headers))
+		for k, v := range cfg.Headers {
+			headers[k] = os.ExpandEnv(v)
+		}
+		return &webhookChannel{
+			url:     os.ExpandEnv(cfg.URL),
+			headers: headers,
+			client:  &http.Client{Timeout: 10 * time.Second},
+		}, nil
+	case channelEmail:
+		if cfg.Host == "" || cfg.From == "" || len(cfg.To) == 0 {
+			return nil, fmt.Errorf("email host, from and to are required")
+		}
+		port := cfg.Port
+		if port == 0 {
+			port = 587
+		}
+		return &emailChannel{
+			addr:     net.JoinHostPort(os.ExpandEnv(cfg.Host), strconv.Itoa(port)),
+			host:     os.ExpandEnv(cfg.Host),
+			username: os.ExpandEnv(cfg.Username),
+			password: os.ExpandEnv(cfg.Password),
+			from:     cfg.From,
+			to:       cfg.To,
+		}, nil
+	case channelCommand:
+		if cfg.Command == "" {
+			return nil, fmt.Errorf("command is required")
+		}
+		return &commandChannel{command: cfg.Command, args: cfg.Args}, nil
+	}
+	return nil, fmt.Errorf("unknown notifier type %q", cfg.Type)
+}
+
+// webhookChannel POSTs the event as JSON.
+type webhookChannel struct {
+	url     string
+	headers map[string]string
+	client  *http.Client
+}
+
+func (w *webhookChannel) Send(e Event) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", w.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range w.headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// emailChannel sends a plain-text email through an SMTP relay, using
+// STARTTLS when the server offers it.
+type emailChannel struct {
+	addr     string
+	host     string
+	username string
+	password string
+	from     string
+	to       []string
+}
+
+func (c *emailChannel) Send(e Event) error {
+	var body strings.Builder
+	fmt.Fprintf(&body, "From: %s\r\n", c.from)
+	fmt.Fprintf(&body, "To: %s\r\n", strings.Join(c.to, ", "))
+	fmt.Fprintf(&body, "Subject: [task-scheduler] %s\r\n", e.Message)
+	fmt.Fprintf(&body, "Date: %s\r\n", e.Time.Format(time.RFC1123Z))
+	body.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	fmt.Fprintf(&body, "%s\r\n", e.Message)
+	if e.Run != nil {
+		fmt.Fprintf(&body, "\r\nStarted:   %s\r\n", e.Run.Start.Format(time.RFC3339))
+		fmt.Fprintf(&body, "Duration:  %s\r\n", e.Run.Duration.Round(time.Millisecond))
+		fmt.Fprintf(&body, "Exit code: %d\r\n", e.Run.ExitCode)
+		if e.Run.Tail != "" {
+			fmt.Fprintf(&body, "\r\nOutput (tail):\r\n%s\r\n", e.Run.Tail)
+		}
+	}
+
+	var auth smtp.Auth
+	if c.username != "" {
+		auth = smtp.PlainAuth("", c.username, c.password, c.host)
+	}
+	return smtp.SendMail(c.addr, auth, c.from, c.to, []byte(body.String()))
+}
+
+// commandChannel runs a local command with the event as JSON on stdin and
+// its main fields in the environment.
+type commandChannel struct {
+	command string
+	args    []string
+}
+
+func (c *commandChannel) Send(e Event) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command(c.command, c.args...)
+	cmd.Stdin = bytes.NewReader(body)
+	cmd.Env = append(os.Environ(),
+		"TASK_NAME="+e.Task,
+		"TASK_EVENT="+e.Event,
+		"TASK_MESSAGE="+e.Message,
+	)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%v: %s", err, bytes.TrimSpace(out))
+	}
+	return nil
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// tailSize is how much of a run's combined output is kept in its run record.
+const tailSize = 4096
+
+// OutputStore keeps the stdout and stderr of every run in files laid out as
+// <dir>/<task>/<start>-<attempt>.{stdout,stderr}, pruning them by age and by
+// total size per task.
+type OutputStore struct {
+	Dir     string
+	MaxAge  time.Duration
+	MaxSize int64
+}
+
+// runOutput is the destination of a single run's output.
+type runOutput struct {
+	stdout *os.File
+	stderr *os.File
+	tail   *tailBuffer
+}
+
+// Open creates the output files of a run. A nil store discards output but
+// still keeps a tail for the run record.
+func (o *OutputStore) Open(task string, start time.Time, attempt int) (*runOutput, error) {
+	out := &runOutput{tail: &tailBuffer{max: tailSize}}
+	if o == nil || o.Dir == "" {
+		return out, nil
+	}
+
+	dir := filepath.Join(o.Dir, task)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	base := filepath.Join(dir, fmt.Sprintf("%s-%d", start.UTC().Format("20060102T150405.000000000Z"), attempt))
+
+	var err error
+	out.stdout, err = os.Create(base + ".stdout")
+	if err != nil {
+		return nil, err
+	}
+	out.stderr, err = os.Create(base + ".stderr")
+	if err != nil {
+		out.stdout.Close()
+		return nil, err
+	}
+	return out, nil
+}
+
+func (r *runOutput) Stdout() io.Writer {
+	if r.stdout == nil {
+		return r.tail
+	}
+	return io.MultiWriter(r.stdout, r.tail)
+}
+
+func (r *runOutput) Stderr() io.Writer {
+	if r.stderr == nil {
+		return r.tail
+	}
+	return io.MultiWriter(r.stderr, r.tail)
+}
+
+// Paths returns the stdout and stderr file names, empty when discarded.
+func (r *runOutput) Paths() (string, string) {
+	if r.stdout == nil {
+		return "", ""
+	}
+	return r.stdout.Name(), r.stderr.Name()
+}
+
+func (r *runOutput) Close() {
+	if r.stdout != nil {
+		r.stdout.Close()
+	}
+	if r.stderr != nil {
+		r.stderr.Close()
+	}
+}
+
+// Prune deletes a task's output files that are older than MaxAge, then the
+// oldest remaining ones until the task's total is within MaxSize.
+func (o *OutputStore) Prune(task string) {
+	if o == nil || o.Dir == "" {
+		return
+	}
+
+	dir := filepath.Join(o.Dir, task)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		log.Printf("Failed to read output directory for task %s: %v", task, err)
+		return
+	}
+
+	type file struct {
+		path    string
+		size    int64
+		modTime time.Time
+	}
+	var files []file
+	var total int64
+	now := time.Now()
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		if o.MaxAge > 0 && now.Sub(info.ModTime()) > o.MaxAge {
+			os.Remove(path)
+			continue
+		}
+		files = append(files, file{path: path, size: info.Size(), modTime: info.ModTime()})
+		total += info.Size()
+	}
+
+	if o.MaxSize <= 0 || total <= o.MaxSize {
+		return
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].modTime.Before(files[j].modTime)
+	})
+	for _, f := range files {
+		if total <= o.MaxSize {
+			break
+		}
+		if err := os.Remove(f.path); err == nil {
+			total -= f.size
+		}
+	}
+}
+
+// tailBuffer keeps the last max bytes written to it. It is shared by a
+// command's stdout and stderr, which exec copies from separate goroutines.
+type tailBuffer struct {
+	mu  sync.Mutex
+	max int
+	buf []byte
+}
+
+func (t *tailBuffer) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.buf = append(t.buf, p...)
+	if len(t.buf) > t.max {
+		t.buf = append(t.buf[:0], t.buf[len(t.buf)-t.max:]...)
+	}
+	return len(p), nil
+}
+
+func (t *tailBuffer) String() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return string(t.buf)
+}
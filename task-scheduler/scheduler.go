@@ -0,0 +1,396 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+var (
+	ErrTaskNotFound = errors.New("task not found")
+	ErrTaskExists   = errors.New("task already exists")
+	ErrTaskInUse    = errors.New("task has dependent tasks")
+	ErrInvalidTask  = errors.New("invalid task")
+)
+
+// Scheduler owns the cron entries for every task and keeps them in sync with
+// the definitions in the jobs file.
+type Scheduler struct {
+	mu       sync.Mutex
+	idle     *sync.Cond
+	cron     *cron.Cron
+	history  *History
+	output   *OutputStore
+	notifier *Notifier
+	tasks    map[string]*Task
+	runs     map[string]*runState
+	joins    map[string]*join
+}
+
+// runState tracks the runs of a task by name, so that overlap policies still
+// apply while a rescheduled task's previous run is finishing.
+type runState struct {
+	running int
+	queued  bool
+}
+
+func NewScheduler(c *cron.Cron, history *History, output *OutputStore, notifier *Notifier) *Scheduler {
+	s := &Scheduler{
+		cron:     c,
+		history:  history,
+		output:   output,
+		notifier: notifier,
+		tasks:    make(map[string]*Task),
+		runs:     make(map[string]*runState),
+		joins:    make(map[string]*join),
+	}
+	s.idle = sync.NewCond(&s.mu)
+	return s
+}
+
+// Reconcile makes the tasks from the jobs file match defs: new tasks are
+// added, missing ones removed and changed ones rescheduled. Tasks whose
+// definition is unchanged keep their cron entry and run state, and tasks added
+// through the API are left alone unless the file defines the same name.
+func (s *Scheduler) Reconcile(defs []Task) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	wanted := make(map[string]bool, len(defs))
+	for i := range defs {
+		def := defs[i]
+		def.Source = sourceFile
+		wanted[def.Name] = true
+
+		current, ok := s.tasks[def.Name]
+		if ok && current.Source == sourceFile && reflect.DeepEqual(current.definition(), def.definition()) {
+			continue
+		}
+		if ok {
+			s.cron.Remove(cron.EntryID(current.ID))
+			delete(s.tasks, def.Name)
+			def.Paused = current.Paused
+			log.Printf("Rescheduling task %s", def.Name)
+		} else {
+			log.Printf("Adding task %s", def.Name)
+		}
+		if err := s.addTask(&def); err != nil {
+			log.Printf("Failed to schedule task %s: %v", def.Name, err)
+		}
+	}
+
+	for name, task := range s.tasks {
+		if task.Source == sourceFile && !wanted[name] {
+			s.cron.Remove(cron.EntryID(task.ID))
+			delete(s.tasks, name)
+			log.Printf("Removed task %s", name)
+		}
+	}
+}
+
+// addTask schedules task, restoring its last outcome from the history;
+// s.mu must be held.
+func (s *Scheduler) addTask(task *Task) error {
+	if last, ok, err := s.history.Last(task.Name); err != nil {
+		log.Printf("Failed to load history for task %s: %v", task.Name, err)
+	} else if ok {
+		task.LastRun = last.Start
+		task.LastStatus = last.Status()
+		s.notifier.Restore(task.Name, last)
+		log.Printf("Task %s last ran at %s: %s", task.Name, task.LastRun.Format(time.RFC3339), task.LastStatus)
+	}
+
+	// Dependent tasks have no cron entry; their upstreams start them.
+	if len(task.DependsOn) == 0 {
+		entryID, err := s.cron.AddFunc(task.cronSpec(), func() {
+			s.scheduledFire(task)
+		})
+		if err != nil {
+			return err
+		}
+		task.ID = int(entryID)
+	}
+	s.tasks[task.Name] = task
+	return nil
+}
+
+// Tasks returns a snapshot of every scheduled task, sorted by name.
+func (s *Scheduler) Tasks() []Task {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tasks := make([]Task, 0, len(s.tasks))
+	for _, task := range s.tasks {
+		tasks = append(tasks, s.snapshot(task))
+	}
+	sort.Slice(tasks, func(i, j int) bool {
+		return tasks[i].Name < tasks[j].Name
+	})
+	return tasks
+}
+
+// Task returns a snapshot of the named task.
+func (s *Scheduler) Task(name string) (Task, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	task, ok := s.tasks[name]
+	if !ok {
+		return Task{}, false
+	}
+	return s.snapshot(task), true
+}
+
+// snapshot copies task and fills in its cron timings; s.mu must be held.
+func (s *Scheduler) snapshot(task *Task) Task {
+	t := *task
+	entry := s.cron.Entry(cron.EntryID(task.ID))
+	t.NextRun = entry.Next
+	t.PrevRun = entry.Prev
+	return t
+}
+
+// AddTask validates and schedules a task that did not come from the jobs
+// file. Such tasks live until they are removed or the scheduler restarts.
+func (s *Scheduler) AddTask(def Task) (Task, error) {
+	def = def.definition()
+	if err := def.validate(); err != nil {
+		return Task{}, fmt.Errorf("%w: %v", ErrInvalidTask, err)
+	}
+	if err := s.notifier.Check(def.Notify); err != nil {
+		return Task{}, fmt.Errorf("%w: task %q: %v", ErrInvalidTask, def.Name, err)
+	}
+	def.Source = sourceAPI
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.tasks[def.Name]; ok {
+		return Task{}, fmt.Errorf("%w: %s", ErrTaskExists, def.Name)
+	}
+	all := []Task{def}
+	for _, task := range s.tasks {
+		all = append(all, *task)
+	}
+	if err := validateGraph(all); err != nil {
+		return Task{}, fmt.Errorf("%w: %v", ErrInvalidTask, err)
+	}
+	task := &def
+	if err := s.addTask(task); err != nil {
+		return Task{}, err
+	}
+	log.Printf("Added task %s through the API", def.Name)
+	return s.snapshot(task), nil
+}
+
+// RemoveTask unschedules the named task. A task from the jobs file comes
+// back the next time the file is reloaded.
+func (s *Scheduler) RemoveTask(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	task, ok := s.tasks[name]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrTaskNotFound, name)
+	}
+	if down := s.downstream(name); len(down) > 0 {
+		return fmt.Errorf("%w: %s is needed by %s", ErrTaskInUse, name, down[0].Name)
+	}
+	s.cron.Remove(cron.EntryID(task.ID))
+	delete(s.tasks, name)
+	log.Printf("Removed task %s through the API", name)
+	return nil
+}
+
+// SetPaused pauses or resumes the named task. Paused tasks keep their cron
+// entry but skip scheduled runs; they can still be triggered by hand.
+func (s *Scheduler) SetPaused(name string, paused bool) (Task, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	task, ok := s.tasks[name]
+	if !ok {
+		return Task{}, fmt.Errorf("%w: %s", ErrTaskNotFound, name)
+	}
+	task.Paused = paused
+	if paused {
+		log.Printf("Paused task %s", name)
+	} else {
+		log.Printf("Resumed task %s", name)
+	}
+	return s.snapshot(task), nil
+}
+
+// Trigger starts a run of the named task immediately, in the background, as
+// the start of a new cycle for its downstream tasks.
+func (s *Scheduler) Trigger(name string) error {
+	s.mu.Lock()
+	task, ok := s.tasks[name]
+	s.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrTaskNotFound, name)
+	}
+	log.Printf("Task %s triggered manually", name)
+	go s.startCycle(task, true)
+	return nil
+}
+
+// History returns up to limit past runs of the named task, newest first.
+func (s *Scheduler) History(name string, limit int) ([]Run, error) {
+	return s.history.Runs(name, limit)
+}
+
+// runTask runs task under its overlap policy, retrying failed attempts with
+// exponential backoff. It reports whether the task succeeded, failed, or was
+// skipped by the overlap policy.
+func (s *Scheduler) runTask(task *Task) outcome {
+	if !s.acquire(task) {
+		return outcomeSkipped
+	}
+	defer s.release(task)
+
+	backoff := time.Duration(task.Backoff)
+	if backoff == 0 {
+		backoff = defaultBackoff
+	}
+	attempts := task.Retries + 1
+
+	stopWatch := s.notifier.WatchLongRunning(task, time.Now())
+	defer stopWatch()
+
+	for attempt := 1; ; attempt++ {
+		run := s.execute(task, attempt)
+		if err := s.history.Record(run); err != nil {
+			log.Printf("Failed to record run of task %s: %v", task.Name, err)
+		}
+
+		s.mu.Lock()
+		task.LastRun = run.Start
+		task.LastStatus = run.Status()
+		s.mu.Unlock()
+
+		if run.Error == "" {
+			log.Printf("Task %s completed successfully (attempt %d/%d)", task.Name, attempt, attempts)
+			s.notifier.RunFinished(task, run)
+			return outcomeSucceeded
+		}
+		if attempt >= attempts {
+			log.Printf("Task %s failed (attempt %d/%d), giving up: %s", task.Name, attempt, attempts, run.Error)
+			s.notifier.RunFinished(task, run)
+			return outcomeFailed
+		}
+		log.Printf("Task %s failed (attempt %d/%d), retrying in %s: %s", task.Name, attempt, attempts, backoff, run.Error)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// acquire applies the overlap policy and reports whether the run may start.
+// With the queue policy it blocks until earlier runs finish; at most one run
+// waits at a time and any further ones are skipped.
+func (s *Scheduler) acquire(task *Task) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	state, ok := s.runs[task.Name]
+	if !ok {
+		state = &runState{}
+		s.runs[task.Name] = state
+	}
+
+	if state.running > 0 {
+		switch task.Overlap {
+		case overlapSkip:
+			log.Printf("Task %s is still running, skipping this run (overlap=%s)", task.Name, task.Overlap)
+			return false
+		case overlapQueue:
+			if state.queued {
+				log.Printf("Task %s already has a queued run, skipping this one (overlap=%s)", task.Name, task.Overlap)
+				return false
+			}
+			log.Printf("Task %s is still running, queueing this run (overlap=%s)", task.Name, task.Overlap)
+			state.queued = true
+			for state.running > 0 {
+				s.idle.Wait()
+			}
+			state.queued = false
+		default:
+			log.Printf("Task %s is still running, starting another run (overlap=%s)", task.Name, overlapAllow)
+		}
+	}
+	state.running++
+	return true
+}
+
+func (s *Scheduler) release(task *Task) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.runs[task.Name].running--
+	s.idle.Broadcast()
+}
+
+// execute runs a single attempt. When the task has a timeout, the whole
+// process group is killed once it expires so that children of shell scripts
+// do not outlive the task.
+func (s *Scheduler) execute(task *Task, attempt int) Run {
+	log.Printf("Running task %s (attempt %d): %s %v", task.Name, attempt, task.Command, task.Args)
+
+	ctx := context.Background()
+	if task.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(task.Timeout))
+		defer cancel()
+	}
+
+	cmd := exec.CommandContext(ctx, task.Command, task.Args...)
+	cmd.Dir = task.Dir
+	cmd.Env = os.Environ()
+	for k, v := range task.Env {
+		cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", k, v))
+	}
+	killProcessGroup(cmd)
+
+	run := Run{Task: task.Name, Attempt: attempt, Start: time.Now()}
+	out, err := s.output.Open(task.Name, run.Start, attempt)
+	if err != nil {
+		log.Printf("Failed to capture output of task %s: %v", task.Name, err)
+		out = &runOutput{tail: &tailBuffer{max: tailSize}}
+	}
+	cmd.Stdout = out.Stdout()
+	cmd.Stderr = out.Stderr()
+
+	err = cmd.Run()
+	out.Close()
+	run.End = time.Now()
+	run.Stdout, run.Stderr = out.Paths()
+	run.Tail = out.tail.String()
+	s.output.Prune(task.Name)
+	run.Duration = run.End.Sub(run.Start)
+	run.ExitCode = exitCode(cmd, err)
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		run.Error = fmt.Sprintf("timed out after %s", time.Duration(task.Timeout))
+	} else if err != nil {
+		run.Error = err.Error()
+	}
+	return run
+}
+
+// exitCode returns the process exit code, or -1 if it never started.
+func exitCode(cmd *exec.Cmd, err error) int {
+	if cmd.ProcessState != nil {
+		return cmd.ProcessState.ExitCode()
+	}
+	if err != nil {
+		return -1
+	}
+	return 0
+}